@@ -18,14 +18,22 @@ type AgentConnectHistory struct {
 }
 
 type TunnelStatHistory struct {
-	ConnectedAt    time.Time     `json:"connected_at,omitzero"    bson:"connected_at,omitempty"`
-	DisconnectedAt time.Time     `json:"disconnected_at,omitzero" bson:"disconnected_at,omitempty"`
-	Second         int64         `json:"second"                   bson:"second"`
-	Library        TunnelLibrary `json:"library"                  bson:"library"`
-	LocalAddr      string        `json:"local_addr,omitzero"      bson:"local_addr,omitempty"`
-	RemoteAddr     string        `json:"remote_addr,omitzero"     bson:"remote_addr,omitempty"`
-	ReceiveBytes   uint64        `json:"receive_bytes,omitzero"   bson:"receive_bytes,omitempty"`  // broker/agent 为主体
-	TransmitBytes  uint64        `json:"transmit_bytes,omitzero"  bson:"transmit_bytes,omitempty"` // broker/agent 为主体
+	ConnectedAt    time.Time          `json:"connected_at,omitzero"    bson:"connected_at,omitempty"`
+	DisconnectedAt time.Time          `json:"disconnected_at,omitzero" bson:"disconnected_at,omitempty"`
+	Second         int64              `json:"second"                   bson:"second"`
+	Library        TunnelLibrary      `json:"library"                  bson:"library"`
+	LocalAddr      string             `json:"local_addr,omitzero"      bson:"local_addr,omitempty"`
+	RemoteAddr     string             `json:"remote_addr,omitzero"     bson:"remote_addr,omitempty"`
+	ReceiveBytes   uint64             `json:"receive_bytes,omitzero"   bson:"receive_bytes,omitempty"`  // broker/agent 为主体
+	TransmitBytes  uint64             `json:"transmit_bytes,omitzero"  bson:"transmit_bytes,omitempty"` // broker/agent 为主体
+	Migrations     []*TunnelMigration `json:"migrations,omitzero"      bson:"migrations,omitempty"`     // 连接迁移记录
+}
+
+// TunnelMigration 通道连接迁移（例如 QUIC 连接迁移）引起的远端地址变化。
+type TunnelMigration struct {
+	From string    `json:"from" bson:"from"`
+	To   string    `json:"to"   bson:"to"`
+	At   time.Time `json:"at"   bson:"at"`
 }
 
 type AgentConnectHistories []*AgentConnectHistory
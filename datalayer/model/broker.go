@@ -13,6 +13,7 @@ type Broker struct {
 	Secret      string          `json:"-"                     bson:"secret,omitempty"`       // 连接密钥
 	Status      bool            `json:"status"                bson:"status"`                 // 状态
 	Config      BrokerConfig    `json:"config,omitempty"      bson:"config,omitempty"`       // 配置
+	ConfigAck   *ConfigAck      `json:"config_ack,omitzero"   bson:"config_ack,omitempty"`   // 节点确认的配置版本
 	Networks    NodeNetworks    `json:"networks,omitzero"     bson:"networks,omitempty"`     // 网卡设备
 	TunnelStat  *TunnelStat     `json:"tunnel_stat,omitzero"  bson:"tunnel_stat,omitempty"`  // 通道连接状态
 	ExecuteStat *ExecuteStat    `json:"execute_stat,omitzero" bson:"execute_stat,omitempty"` // broker 执行程序信息
@@ -22,6 +23,12 @@ type Broker struct {
 
 type Brokers []*Broker
 
+// ConfigAck 节点确认的配置版本。
+type ConfigAck struct {
+	Version string    `json:"version"  bson:"version"`
+	AckedAt time.Time `json:"acked_at" bson:"acked_at"`
+}
+
 type BrokerConfig struct {
 	Server BrokerServerConfig `json:"server" bson:"server"`
 	Logger BrokerLoggerConfig `json:"logger" bson:"logger"`
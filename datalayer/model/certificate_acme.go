@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// CertificateChallenge ACME 验证的应答，多个 broker 共享，任意一个 broker 都能应答验证请求。
+type CertificateChallenge struct {
+	ID        string    `json:"id"         bson:"_id"`        // 验证方式和 token 或域名，例如 http-01:<token>
+	Value     string    `json:"-"          bson:"value"`      // key authorization
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"` // 过期后由 TTL 索引删除
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// CertificateLease 证书续期的租约，同一时刻只有持有者执行续期。
+type CertificateLease struct {
+	ID        string    `json:"id"         bson:"_id"`        // 租约名
+	Holder    string    `json:"holder"     bson:"holder"`     // 持有者
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"` // 过期后其它持有者可以抢占
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// CertificateAuthority 内部 CA 的根证书，由 tlscert.CA 生成和解析。
+type CertificateAuthority struct {
+	ID                bson.ObjectID `json:"id,omitzero"         bson:"_id,omitempty"`
+	Generation        int64         `json:"generation"          bson:"generation"` // 根证书的代数，从 1 开始递增，唯一
+	CommonName        string        `json:"common_name"         bson:"common_name"`
+	Certificate       string        `json:"certificate"         bson:"certificate"` // PEM 格式的根证书
+	PrivateKey        string        `json:"-"                   bson:"private_key"` // PEM 格式的私钥，默认由 tlscert.CAConfig.KeyEncryptionKey 加密
+	CertificateSHA256 string        `json:"certificate_sha256"  bson:"certificate_sha256"`
+	NotBefore         time.Time     `json:"not_before"          bson:"not_before"`
+	NotAfter          time.Time     `json:"not_after"           bson:"not_after"`
+	Revoked           bool          `json:"revoked"             bson:"revoked"` // 紧急轮换后旧的根证书被吊销，不再被信任
+	RevokedAt         time.Time     `json:"revoked_at,omitzero" bson:"revoked_at,omitempty"`
+	CreatedAt         time.Time     `json:"created_at,omitzero" bson:"created_at,omitempty"`
+}
+
+type CertificateAuthorities []*CertificateAuthority
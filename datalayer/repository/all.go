@@ -19,6 +19,7 @@ type All interface {
 	BrokerConnectHistory() BrokerConnectHistory
 	BrokerRelease() BrokerRelease
 	Certificate() Certificate
+	CertificateAuthority() CertificateAuthority
 	Firewall() Firewall
 	FS() FS
 	Maxmind() Maxmind
@@ -40,6 +41,7 @@ func NewAll(db *mongo.Database, log *slog.Logger) All {
 		brokerConnectHistory: NewBrokerConnectHistory(db),
 		brokerRelease:        NewBrokerRelease(db),
 		certificate:          NewCertificate(db),
+		certificateAuthority: NewCertificateAuthority(db),
 		firewall:             NewFirewall(db),
 		fs:                   NewFS(db),
 		maxmind:              NewMaxmind(db),
@@ -60,6 +62,7 @@ type allRepo struct {
 	brokerConnectHistory BrokerConnectHistory
 	brokerRelease        BrokerRelease
 	certificate          Certificate
+	certificateAuthority CertificateAuthority
 	firewall             Firewall
 	fs                   FS
 	maxmind              Maxmind
@@ -78,6 +81,7 @@ func (ar *allRepo) Broker() Broker                             { return ar.broke
 func (ar *allRepo) BrokerConnectHistory() BrokerConnectHistory { return ar.brokerConnectHistory }
 func (ar *allRepo) BrokerRelease() BrokerRelease               { return ar.brokerRelease }
 func (ar *allRepo) Certificate() Certificate                   { return ar.certificate }
+func (ar *allRepo) CertificateAuthority() CertificateAuthority { return ar.certificateAuthority }
 func (ar *allRepo) Firewall() Firewall                         { return ar.firewall }
 func (ar *allRepo) FS() FS                                     { return ar.fs }
 func (ar *allRepo) Maxmind() Maxmind                           { return ar.maxmind }
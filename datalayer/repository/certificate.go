@@ -2,7 +2,13 @@ package repository
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"time"
 
 	"github.com/xmx/aegis-control/datalayer/model"
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -13,6 +19,30 @@ import (
 type Certificate interface {
 	Repository[bson.ObjectID, model.Certificate, model.Certificates]
 	Enables(context.Context) ([]*tls.Certificate, error)
+
+	// WatchChanges 监听证书表的变更，每次变更后调用 changed，直到 ctx 取消或 change stream 出错。
+	// 可以作为 tlscert.RefreshConfig.Watch 使用。
+	WatchChanges(ctx context.Context, changed func()) error
+
+	// LoadCertificate 按名字查询证书，不存在时返回 nil, nil。
+	// 与 SaveCertificate 以及下面的验证应答、租约方法一起实现了 tlscert.ACMEStore。
+	LoadCertificate(ctx context.Context, name string) (*tls.Certificate, error)
+
+	// SaveCertificate 解析 PEM 格式的证书链和私钥，按名字创建或替换证书记录并启用。
+	SaveCertificate(ctx context.Context, name string, chain, key []byte) error
+
+	// PutChallenge 保存 ACME 验证的应答，保存在 certificate_challenge 表中，ttl 后自动删除。
+	PutChallenge(ctx context.Context, key, value string, ttl time.Duration) error
+
+	// GetChallenge 查询 ACME 验证的应答，不存在或已过期时返回空字符串。
+	GetChallenge(ctx context.Context, key string) (string, error)
+
+	// DeleteChallenge 删除 ACME 验证的应答。
+	DeleteChallenge(ctx context.Context, key string) error
+
+	// AcquireLease 获取或续约名为 name 的租约，租约保存在 certificate_lease 表中，
+	// 被其它持有者持有且未过期时返回 false。
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
 }
 
 func NewCertificate(db *mongo.Database, opts ...options.Lister[options.CollectionOptions]) Certificate {
@@ -21,11 +51,15 @@ func NewCertificate(db *mongo.Database, opts ...options.Lister[options.Collectio
 
 	return &certificateRepo{
 		Repository: repo,
+		challenges: db.Collection("certificate_challenge", opts...),
+		leases:     db.Collection("certificate_lease", opts...),
 	}
 }
 
 type certificateRepo struct {
 	Repository[bson.ObjectID, model.Certificate, model.Certificates]
+	challenges *mongo.Collection
+	leases     *mongo.Collection
 }
 
 // Enables 主要是给 tlscert 使用。
@@ -48,6 +82,103 @@ func (r *certificateRepo) Enables(ctx context.Context) ([]*tls.Certificate, erro
 	return rets, nil
 }
 
+func (r *certificateRepo) WatchChanges(ctx context.Context, changed func()) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": []string{"insert", "update", "replace", "delete"}}}}},
+	}
+	stream, err := r.Watch(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		changed()
+	}
+
+	return stream.Err()
+}
+
+func (r *certificateRepo) LoadCertificate(ctx context.Context, name string) (*tls.Certificate, error) {
+	dat, err := r.FindOne(ctx, bson.D{{"name", name}})
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			err = nil
+		}
+		return nil, err
+	}
+	pair, err := tls.X509KeyPair([]byte(dat.PublicKey), []byte(dat.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &pair, nil
+}
+
+func (r *certificateRepo) SaveCertificate(ctx context.Context, name string, chain, key []byte) error {
+	mod, err := newCertificateModel(name, chain, key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	mod.UpdatedAt = now
+	opt := options.UpdateOne().SetUpsert(true)
+	update := bson.M{"$set": mod, "$setOnInsert": bson.M{"created_at": now}}
+	_, err = r.UpdateOne(ctx, bson.D{{"name", name}}, update, opt)
+
+	return err
+}
+
+func (r *certificateRepo) PutChallenge(ctx context.Context, key, value string, ttl time.Duration) error {
+	now := time.Now()
+	dat := &model.CertificateChallenge{ID: key, Value: value, ExpiresAt: now.Add(ttl), CreatedAt: now}
+	opt := options.Replace().SetUpsert(true)
+	_, err := r.challenges.ReplaceOne(ctx, bson.D{{"_id", key}}, dat, opt)
+
+	return err
+}
+
+func (r *certificateRepo) GetChallenge(ctx context.Context, key string) (string, error) {
+	// TTL 索引的删除有延迟，所以查询时也要检查是否过期。
+	filter := bson.D{{"_id", key}, {"expires_at", bson.M{"$gt": time.Now()}}}
+	dat := new(model.CertificateChallenge)
+	if err := r.challenges.FindOne(ctx, filter).Decode(dat); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			err = nil
+		}
+		return "", err
+	}
+
+	return dat.Value, nil
+}
+
+func (r *certificateRepo) DeleteChallenge(ctx context.Context, key string) error {
+	_, err := r.challenges.DeleteOne(ctx, bson.D{{"_id", key}})
+	return err
+}
+
+func (r *certificateRepo) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	filter := bson.D{
+		{"_id", name},
+		{"$or", bson.A{bson.D{{"holder", holder}}, bson.D{{"expires_at", bson.M{"$lte": now}}}}},
+	}
+	update := bson.M{"$set": bson.M{"holder": holder, "expires_at": now.Add(ttl)}}
+	opt := options.UpdateOne().SetUpsert(true)
+
+	// 租约被其它持有者持有时 filter 不匹配，upsert 插入相同的 _id 会违反唯一约束。
+	if _, err := r.leases.UpdateOne(ctx, filter, update, opt); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
 func (r *certificateRepo) CreateIndex(ctx context.Context) error {
 	idx := []mongo.IndexModel{
 		{
@@ -59,7 +190,78 @@ func (r *certificateRepo) CreateIndex(ctx context.Context) error {
 			Options: options.Index().SetUnique(true),
 		},
 	}
-	_, err := r.Indexes().CreateMany(ctx, idx)
+	if _, err := r.Indexes().CreateMany(ctx, idx); err != nil {
+		return err
+	}
+
+	ttl := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err := r.challenges.Indexes().CreateOne(ctx, ttl)
 
 	return err
 }
+
+// newCertificateModel 根据 PEM 格式的证书链和私钥生成证书记录，字段取自证书链的第一张证书。
+func newCertificateModel(name string, chain, key []byte) (*model.Certificate, error) {
+	pair, err := tls.X509KeyPair(chain, key)
+	if err != nil {
+		return nil, err
+	}
+	leaf := pair.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	ips := make([]string, 0, len(leaf.IPAddresses))
+	for _, ip := range leaf.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	uris := make([]string, 0, len(leaf.URIs))
+	for _, u := range leaf.URIs {
+		uris = append(uris, u.String())
+	}
+
+	return &model.Certificate{
+		Name:               name,
+		Enabled:            true,
+		CommonName:         leaf.Subject.CommonName,
+		PublicKey:          string(chain),
+		PrivateKey:         string(key),
+		CertificateSHA256:  sha256Hex(leaf.Raw),
+		PublicKeySHA256:    sha256Hex(leaf.RawSubjectPublicKeyInfo),
+		PrivateKeySHA256:   sha256Hex(key),
+		DNSNames:           leaf.DNSNames,
+		IPAddresses:        ips,
+		EmailAddresses:     leaf.EmailAddresses,
+		URIs:               uris,
+		Version:            leaf.Version,
+		NotBefore:          leaf.NotBefore,
+		NotAfter:           leaf.NotAfter,
+		Issuer:             certificatePKIXName(leaf.Issuer),
+		Subject:            certificatePKIXName(leaf.Subject),
+		SignatureAlgorithm: leaf.SignatureAlgorithm.String(),
+	}, nil
+}
+
+func certificatePKIXName(n pkix.Name) model.CertificatePKIXName {
+	return model.CertificatePKIXName{
+		Country:            n.Country,
+		Organization:       n.Organization,
+		OrganizationalUnit: n.OrganizationalUnit,
+		Locality:           n.Locality,
+		Province:           n.Province,
+		StreetAddress:      n.StreetAddress,
+		PostalCode:         n.PostalCode,
+		SerialNumber:       n.SerialNumber,
+		CommonName:         n.CommonName,
+	}
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type CertificateAuthority interface {
+	Repository[bson.ObjectID, model.CertificateAuthority, model.CertificateAuthorities]
+
+	// LoadRoots 查询所有根证书，包括已过期和已吊销的，与 InsertRoot、RevokeRoots 一起实现了 tlscert.CAStore。
+	LoadRoots(ctx context.Context) (model.CertificateAuthorities, error)
+
+	// InsertRoot 保存新生成的根证书，相同 Generation 的根证书已存在时返回 false。
+	InsertRoot(ctx context.Context, root *model.CertificateAuthority) (bool, error)
+
+	// RevokeRoots 吊销 Generation 小于 generation 的所有根证书。
+	RevokeRoots(ctx context.Context, generation int64) error
+
+	// WatchChanges 监听根证书的变更，可以作为 tlscert.RefreshConfig.Watch 使用，
+	// 使其它 broker 轮换的根证书尽快生效。
+	WatchChanges(ctx context.Context, changed func()) error
+}
+
+func NewCertificateAuthority(db *mongo.Database, opts ...options.Lister[options.CollectionOptions]) CertificateAuthority {
+	coll := db.Collection("certificate_authority", opts...)
+	repo := NewRepository[bson.ObjectID, model.CertificateAuthority, model.CertificateAuthorities](coll)
+
+	return &certificateAuthorityRepo{
+		Repository: repo,
+	}
+}
+
+type certificateAuthorityRepo struct {
+	Repository[bson.ObjectID, model.CertificateAuthority, model.CertificateAuthorities]
+}
+
+func (r *certificateAuthorityRepo) LoadRoots(ctx context.Context) (model.CertificateAuthorities, error) {
+	return r.Find(ctx, bson.D{})
+}
+
+func (r *certificateAuthorityRepo) InsertRoot(ctx context.Context, root *model.CertificateAuthority) (bool, error) {
+	// generation 上有唯一索引，多个 broker 同时生成同一代根证书时只有一个能插入成功。
+	if _, err := r.InsertOne(ctx, root); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *certificateAuthorityRepo) RevokeRoots(ctx context.Context, generation int64) error {
+	filter := bson.D{{"generation", bson.M{"$lt": generation}}, {"revoked", false}}
+	update := bson.M{"$set": bson.M{"revoked": true, "revoked_at": time.Now()}}
+	_, err := r.UpdateMany(ctx, filter, update)
+
+	return err
+}
+
+func (r *certificateAuthorityRepo) WatchChanges(ctx context.Context, changed func()) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": []string{"insert", "update", "replace", "delete"}}}}},
+	}
+	stream, err := r.Watch(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		changed()
+	}
+
+	return stream.Err()
+}
+
+func (r *certificateAuthorityRepo) CreateIndex(ctx context.Context) error {
+	idx := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "certificate_sha256", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "generation", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"generation": bson.M{"$gt": 0}}),
+		},
+		{Keys: bson.D{{Key: "not_after", Value: -1}}},
+	}
+	_, err := r.Indexes().CreateMany(ctx, idx)
+
+	return err
+}
@@ -1,6 +1,7 @@
 package httpnet
 
 import (
+	"errors"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -16,6 +17,12 @@ func NewReverse(trip http.RoundTripper) *httputil.ReverseProxy {
 		},
 		Transport: trip,
 		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			var pd *problem.Details
+			if errors.As(err, &pd) {
+				_ = pd.JSON(w)
+				return
+			}
+
 			code := http.StatusBadGateway
 			pb := &problem.Details{
 				Host:     r.Host,
@@ -0,0 +1,425 @@
+package linkhub
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+	"github.com/xmx/aegis-control/datalayer/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// DefaultConfigPath 节点接收配置的默认路径。
+	DefaultConfigPath = "/api/tunnel/config"
+
+	configVersionHeader = "X-Config-Version"
+)
+
+// PeerConfigFunc 按节点加载配置的 ConfigLoader，节点从 ctx 中获取，见 WithValue。
+type PeerConfigFunc[T any] func(ctx context.Context, p Peer) (*T, error)
+
+func (f PeerConfigFunc[T]) LoadConfig(ctx context.Context) (*T, error) {
+	p, ok := FromContext(ctx)
+	if !ok {
+		return nil, errors.New("no peer in context")
+	}
+
+	return f(ctx, p)
+}
+
+// BrokerConfigLoader 从 broker 表中加载 broker 的配置。
+func BrokerConfigLoader(repo repository.Broker) ConfigLoader[model.BrokerConfig] {
+	return PeerConfigFunc[model.BrokerConfig](func(ctx context.Context, p Peer) (*model.BrokerConfig, error) {
+		brk, err := repo.FindByID(ctx, p.ID())
+		if err != nil {
+			return nil, err
+		}
+
+		return &brk.Config, nil
+	})
+}
+
+// ConfigAckStore 持久化节点确认的配置版本，重启后据此判断是否需要重新下发。
+type ConfigAckStore interface {
+	// LoadAck 读取节点确认的版本，没有记录时返回 nil。
+	LoadAck(ctx context.Context, id bson.ObjectID) (*model.ConfigAck, error)
+
+	// SaveAck 保存节点确认的版本。
+	SaveAck(ctx context.Context, id bson.ObjectID, ack *model.ConfigAck) error
+}
+
+// BrokerConfigAckStore 将确认的版本保存在 broker 表的 config_ack 字段。
+func BrokerConfigAckStore(repo repository.Broker) ConfigAckStore {
+	return &brokerConfigAck{repo: repo}
+}
+
+type brokerConfigAck struct {
+	repo repository.Broker
+}
+
+func (b *brokerConfigAck) LoadAck(ctx context.Context, id bson.ObjectID) (*model.ConfigAck, error) {
+	opt := options.FindOne().SetProjection(bson.M{"config_ack": 1})
+	brk, err := b.repo.FindByID(ctx, id, opt)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return brk.ConfigAck, nil
+}
+
+func (b *brokerConfigAck) SaveAck(ctx context.Context, id bson.ObjectID, ack *model.ConfigAck) error {
+	_, err := b.repo.UpdateByID(ctx, id, bson.M{"$set": bson.M{"config_ack": ack}})
+	return err
+}
+
+// ConfigState 节点配置的下发状态。
+type ConfigState struct {
+	ID       bson.ObjectID `json:"id"`
+	Host     string        `json:"host"`
+	Desired  string        `json:"desired"`            // 最新配置的版本
+	Acked    string        `json:"acked,omitzero"`     // 节点确认的版本
+	AckedAt  time.Time     `json:"acked_at,omitzero"`  // 节点确认时间
+	PushedAt time.Time     `json:"pushed_at,omitzero"` // 最近一次下发时间
+	Error    string        `json:"error,omitzero"`     // 最近一次下发的错误
+}
+
+// Stale 节点运行的配置是否不是最新版本。
+func (s ConfigState) Stale() bool {
+	return s.Acked != s.Desired
+}
+
+// NewConfigPusher 配置分发器，按节点加载配置并计算版本，版本变化时通过节点通道下发，
+// 节点一侧使用 NewConfigReceiver 接收。store 用于持久化节点确认的版本，可以为 nil。
+//
+// 加载配置时 ctx 中带有目标节点，按节点加载的配置可以使用 PeerConfigFunc。
+func NewConfigPusher[T any](hub Huber, load ConfigLoader[T], store ConfigAckStore, log *slog.Logger) *ConfigPusher[T] {
+	return &ConfigPusher[T]{
+		hub:    hub,
+		load:   load,
+		store:  store,
+		log:    log,
+		cli:    &http.Client{Transport: NewTransport(hub), Timeout: 30 * time.Second},
+		path:   DefaultConfigPath,
+		states: make(map[bson.ObjectID]*ConfigState, 64),
+	}
+}
+
+type ConfigPusher[T any] struct {
+	hub    Huber
+	load   ConfigLoader[T]
+	store  ConfigAckStore
+	log    *slog.Logger
+	cli    *http.Client
+	path   string
+	mutex  sync.Mutex
+	states map[bson.ObjectID]*ConfigState
+}
+
+// Push 加载节点配置，与节点已确认的版本不一致或 force 为 true 时下发。
+// 节点已经不在连接池中时直接返回。
+func (cp *ConfigPusher[T]) Push(ctx context.Context, p Peer, force bool) error {
+	state, err := cp.state(ctx, p)
+	if err != nil || state == nil {
+		return err
+	}
+
+	cfg, err := cp.load.LoadConfig(WithValue(ctx, p))
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(raw)
+	version := hex.EncodeToString(sum[:])
+
+	cp.mutex.Lock()
+	state.Desired = version
+	acked := state.Acked
+	cp.mutex.Unlock()
+	if !force && acked == version {
+		return nil
+	}
+
+	err = cp.send(ctx, p, version, raw)
+
+	cp.mutex.Lock()
+	state.PushedAt = time.Now()
+	if err != nil {
+		state.Error = err.Error()
+	} else {
+		state.Error = ""
+		state.Acked = version
+		state.AckedAt = state.PushedAt
+	}
+	cp.mutex.Unlock()
+
+	if err == nil && cp.store != nil {
+		ack := &model.ConfigAck{Version: version, AckedAt: time.Now()}
+		if exx := cp.store.SaveAck(ctx, p.ID(), ack); exx != nil {
+			cp.log.Warn("保存节点配置确认版本出错", "id", p.ID(), "version", version, "error", exx)
+		}
+	}
+
+	attrs := []any{"id", p.ID(), "version", version}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+		cp.log.Warn("节点配置下发失败", attrs...)
+	} else {
+		cp.log.Info("节点配置下发成功", attrs...)
+	}
+
+	return err
+}
+
+// PushAll 向所有在线节点检查并下发配置。
+func (cp *ConfigPusher[T]) PushAll(ctx context.Context, force bool) (*FanoutReport, error) {
+	fn := func(ctx context.Context, p Peer) error {
+		return cp.Push(ctx, p, force)
+	}
+
+	return Fanout(ctx, cp.hub, SelectAll(), fn, FanoutConfig{Concurrency: 32, Timeout: time.Minute})
+}
+
+// configPushers Run 中节点上线后下发配置的协程数，configPending 为等待下发的队列长度。
+const (
+	configPushers = 16
+	configPending = 1024
+)
+
+// Run 节点上线时下发配置，并按照 interval 周期性检查配置变化，直到 ctx 取消。
+//
+// 上线下发由固定数量的协程完成，队列满时跳过，等待下一次周期检查补发。
+func (cp *ConfigPusher[T]) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	sub := cp.hub.Subscribe(1024, DropOldest)
+	defer sub.Close()
+
+	pending := make(chan Peer, configPending)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(pending)
+	for range configPushers {
+		wg.Go(func() {
+			for p := range pending {
+				_ = cp.Push(ctx, p, true)
+			}
+		})
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			_, _ = cp.PushAll(ctx, false)
+		case evt, ok := <-sub.C():
+			if !ok {
+				return nil
+			}
+			switch evt.Type {
+			case EventJoin, EventReplace:
+				cp.forget(evt.Peer.ID())
+				select {
+				case pending <- evt.Peer:
+				default:
+					cp.log.Warn("节点配置下发队列已满，等待周期检查时下发", "id", evt.Peer.ID())
+				}
+			case EventLeave:
+				cp.forget(evt.Peer.ID())
+			}
+		}
+	}
+}
+
+// States 各在线节点的配置状态。
+func (cp *ConfigPusher[T]) States() []ConfigState {
+	cp.mutex.Lock()
+	rets := make([]ConfigState, 0, len(cp.states))
+	for _, s := range cp.states {
+		rets = append(rets, *s)
+	}
+	cp.mutex.Unlock()
+
+	slices.SortFunc(rets, func(a, b ConfigState) int { return bytes.Compare(a.ID[:], b.ID[:]) })
+
+	return rets
+}
+
+// Stales 运行着旧版本配置的节点。
+func (cp *ConfigPusher[T]) Stales() []ConfigState {
+	var rets []ConfigState
+	for _, s := range cp.States() {
+		if s.Stale() {
+			rets = append(rets, s)
+		}
+	}
+
+	return rets
+}
+
+func (cp *ConfigPusher[T]) send(ctx context.Context, p Peer, version string, raw []byte) error {
+	reqURL := "http://" + p.Host() + cp.path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, reqURL, bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(configVersionHeader, version)
+
+	res, err := cp.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return &ConfigPushError{ID: p.ID(), StatusCode: res.StatusCode, Body: body}
+	}
+	if acked := res.Header.Get(configVersionHeader); acked != version {
+		return &ConfigPushError{ID: p.ID(), StatusCode: res.StatusCode, Body: []byte("acked version mismatch: " + acked)}
+	}
+
+	return nil
+}
+
+// state 获取节点的下发状态，节点已不在连接池中时返回 nil，避免为已离开的节点重新创建状态。
+// 首次创建时从 store 读取节点确认过的版本。
+func (cp *ConfigPusher[T]) state(ctx context.Context, p Peer) (*ConfigState, error) {
+	id := p.ID()
+	cp.mutex.Lock()
+	state := cp.states[id]
+	cp.mutex.Unlock()
+	if state != nil {
+		return state, nil
+	}
+
+	var ack *model.ConfigAck
+	if cp.store != nil {
+		var err error
+		if ack, err = cp.store.LoadAck(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	// 节点离开时先从连接池删除再发布事件，所以这里检查通过后 forget 一定会在之后执行。
+	if cp.hub.GetID(id) != p {
+		return nil, nil
+	}
+	if state = cp.states[id]; state == nil {
+		state = &ConfigState{ID: id, Host: p.Host()}
+		if ack != nil {
+			state.Acked, state.AckedAt = ack.Version, ack.AckedAt
+		}
+		cp.states[id] = state
+	}
+
+	return state, nil
+}
+
+func (cp *ConfigPusher[T]) forget(id bson.ObjectID) {
+	cp.mutex.Lock()
+	delete(cp.states, id)
+	cp.mutex.Unlock()
+}
+
+type ConfigPushError struct {
+	ID         bson.ObjectID
+	StatusCode int
+	Body       []byte
+}
+
+func (e *ConfigPushError) Error() string {
+	return "push config to " + e.ID.Hex() + " failed, status=" +
+		http.StatusText(e.StatusCode) + ", body='" + string(e.Body) + "'"
+}
+
+// NewConfigReceiver 节点一侧接收下发配置的 http.Handler，同时实现了 ConfigLoader 用于读取最新的配置。
+//
+// apply 用于应用新配置，返回错误时视为未确认，可以为 nil。
+func NewConfigReceiver[T any](apply func(context.Context, *T) error) *ConfigReceiver[T] {
+	return &ConfigReceiver[T]{apply: apply}
+}
+
+type ConfigReceiver[T any] struct {
+	apply   func(context.Context, *T) error
+	mutex   sync.RWMutex
+	version string
+	config  *T
+}
+
+func (cr *ConfigReceiver[T]) LoadConfig(context.Context) (*T, error) {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+
+	return cr.config, nil
+}
+
+// Version 当前配置的版本。
+func (cr *ConfigReceiver[T]) Version() string {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+
+	return cr.version
+}
+
+func (cr *ConfigReceiver[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, err := io.ReadAll(io.LimitReader(r.Body, 4<<20))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sum := sha256.Sum256(raw)
+	version := hex.EncodeToString(sum[:])
+	if want := r.Header.Get(configVersionHeader); want != "" && want != version {
+		http.Error(w, "config checksum mismatch", http.StatusBadRequest)
+		return
+	}
+
+	cfg := new(T)
+	if err = json.Unmarshal(raw, cfg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	if cr.apply != nil {
+		if err = cr.apply(ctx, cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	cr.mutex.Lock()
+	cr.version, cr.config = version, cfg
+	cr.mutex.Unlock()
+
+	w.Header().Set(configVersionHeader, version)
+	w.WriteHeader(http.StatusNoContent)
+}
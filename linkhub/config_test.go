@@ -0,0 +1,83 @@
+package linkhub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type memoryAckStore map[bson.ObjectID]*model.ConfigAck
+
+func (m memoryAckStore) LoadAck(_ context.Context, id bson.ObjectID) (*model.ConfigAck, error) {
+	return m[id], nil
+}
+
+func (m memoryAckStore) SaveAck(_ context.Context, id bson.ObjectID, ack *model.ConfigAck) error {
+	m[id] = ack
+	return nil
+}
+
+func TestConfigPusherStates(t *testing.T) {
+	cfg := &model.BrokerConfig{Server: model.BrokerServerConfig{Addr: ":443"}}
+	raw, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(raw)
+	version := hex.EncodeToString(sum[:])
+	var loaded []Peer
+	load := PeerConfigFunc[model.BrokerConfig](func(_ context.Context, p Peer) (*model.BrokerConfig, error) {
+		loaded = append(loaded, p)
+		return cfg, nil
+	})
+
+	hub := NewHub("example.com")
+	online, _ := hub.Put(bson.NewObjectID(), newStubMuxer(1), stubInfo(1), time.Now())
+	gone, _ := hub.Put(bson.NewObjectID(), newStubMuxer(2), stubInfo(2), time.Now())
+	hub.DelPeer(gone)
+
+	// 持久化的确认版本与当前配置一致，重启后不需要重新下发。
+	store := memoryAckStore{online.ID(): {Version: version, AckedAt: time.Now()}}
+	cp := NewConfigPusher(hub, load, store, slog.New(slog.DiscardHandler))
+	ctx := context.Background()
+	if err := cp.Push(ctx, online, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.Push(ctx, gone, false); err != nil {
+		t.Fatal(err)
+	}
+
+	states := cp.States()
+	if len(states) != 1 || states[0].ID != online.ID() {
+		t.Fatalf("States() = %+v, want only the online peer", states)
+	}
+	if len(loaded) != 1 || loaded[0] != online {
+		t.Fatalf("config loaded for %v, want the online peer", loaded)
+	}
+	if states[0].Stale() || !states[0].PushedAt.IsZero() {
+		t.Fatalf("state = %+v, want acked from store without push", states[0])
+	}
+}
+
+// TestPeerConfigFunc 按节点的配置从 ctx 中获取节点，ctx 中没有节点时返回错误。
+func TestPeerConfigFunc(t *testing.T) {
+	hub := NewHub("example.com")
+	p, _ := hub.Put(bson.NewObjectID(), newStubMuxer(1), stubInfo(1), time.Now())
+	load := PeerConfigFunc[string](func(_ context.Context, p Peer) (*string, error) {
+		host := p.Host()
+		return &host, nil
+	})
+
+	var loader ConfigLoader[string] = load
+	got, err := loader.LoadConfig(WithValue(context.Background(), p))
+	if err != nil || *got != p.Host() {
+		t.Fatalf("LoadConfig() = %v, %v", got, err)
+	}
+	if _, err = loader.LoadConfig(context.Background()); err == nil {
+		t.Fatal("LoadConfig() without peer succeeded")
+	}
+}
@@ -0,0 +1,198 @@
+package linkhub
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var ErrPeerOffline = errors.New("peer is offline")
+
+// Location 节点在集群中的位置。
+type Location struct {
+	ID       bson.ObjectID `json:"id"`        // 节点 ID（例如 agent）
+	NodeID   bson.ObjectID `json:"node_id"`   // 节点所连接的 node ID（例如 broker）
+	NodeName string        `json:"node_name"` // 节点所连接的 node 名字
+}
+
+// DirectoryBackend 集群节点目录的存储后端。
+type DirectoryBackend interface {
+	// Lookup 查询节点所在位置，节点不在线时返回 ErrPeerOffline。
+	Lookup(ctx context.Context, id bson.ObjectID) (Location, error)
+
+	// Publish 发布节点上下线。
+	Publish(ctx context.Context, loc Location, online bool) error
+}
+
+// DirectoryConfig 集群节点目录配置。
+type DirectoryConfig struct {
+	NodeID   bson.ObjectID    // 当前 node 的 ID
+	NodeName string           // 当前 node 的名字
+	Local    Huber            // 直接连接到当前 node 的节点，可以为 nil
+	Nodes    Huber            // 当前进程可以直连的其它 node，转发时使用，可以为 nil
+	Backend  DirectoryBackend // 存储后端
+}
+
+// Directory 跨 node 的集群节点目录，在 Huber 之上查询任意节点所在的 node，
+// 并能通过该 node 转发流。
+type Directory struct {
+	cfg DirectoryConfig
+}
+
+func NewDirectory(cfg DirectoryConfig) *Directory {
+	return &Directory{cfg: cfg}
+}
+
+// Resolve 查询节点所在位置，优先查询本地连接池。
+func (d *Directory) Resolve(ctx context.Context, id bson.ObjectID) (Location, error) {
+	if local := d.cfg.Local; local != nil {
+		if p := local.GetID(id); p != nil {
+			return d.localLocation(p), nil
+		}
+	}
+
+	return d.cfg.Backend.Lookup(ctx, id)
+}
+
+// Open 打开到节点的流，节点连接在本 node 时直接打开，否则经由其所在的 node 转发。
+func (d *Directory) Open(ctx context.Context, id bson.ObjectID) (net.Conn, error) {
+	if local := d.cfg.Local; local != nil {
+		if p := local.GetID(id); p != nil {
+			return p.Muxer().Open(ctx)
+		}
+	}
+
+	loc, err := d.cfg.Backend.Lookup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var node Peer
+	if nodes := d.cfg.Nodes; nodes != nil {
+		node = nodes.GetID(loc.NodeID)
+	}
+	if node == nil {
+		return nil, &net.OpError{Op: "dial", Net: "forward", Err: ErrPeerOffline,
+			Addr: &net.UnixAddr{Net: "forward", Name: loc.NodeID.Hex()}}
+	}
+
+	return DialForward(ctx, node, id)
+}
+
+// Run 将本地连接池的节点上下线同步到存储后端，直到 ctx 取消。
+//
+// 订阅缓冲区满时事件会被丢弃，发现丢弃后以 Peers() 快照为准全量重新同步，
+// 避免目录因丢失的事件永久错误。事件只作为变更通知，发布的状态总是取自连接池的当前状态，
+// 这样重新同步之后再处理积压的旧事件也不会写回过期的状态。
+func (d *Directory) Run(ctx context.Context) error {
+	local := d.cfg.Local
+	if local == nil {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	sub := local.Subscribe(1024, DropNewest)
+	defer sub.Close()
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	published := make(map[bson.ObjectID]struct{}, 1024)
+	d.resync(ctx, published)
+	var dropped uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case evt, ok := <-sub.C():
+			if !ok {
+				return nil
+			}
+			switch evt.Type {
+			case EventJoin, EventReplace, EventLeave:
+				d.sync(ctx, evt.Peer.ID(), published)
+			}
+		}
+
+		if n := sub.Dropped(); n != dropped {
+			dropped = n
+			d.resync(ctx, published)
+		}
+	}
+}
+
+// sync 按连接池的当前状态发布单个节点。
+func (d *Directory) sync(ctx context.Context, id bson.ObjectID, published map[bson.ObjectID]struct{}) {
+	if p := d.cfg.Local.GetID(id); p != nil {
+		published[id] = struct{}{}
+		_ = d.cfg.Backend.Publish(ctx, d.localLocation(p), true)
+		return
+	}
+
+	delete(published, id)
+	loc := Location{ID: id, NodeID: d.cfg.NodeID, NodeName: d.cfg.NodeName}
+	_ = d.cfg.Backend.Publish(ctx, loc, false)
+}
+
+// resync 以本地连接池的快照为准全量同步，published 记录已发布为在线的节点。
+func (d *Directory) resync(ctx context.Context, published map[bson.ObjectID]struct{}) {
+	peers := d.cfg.Local.Peers()
+	online := make(map[bson.ObjectID]struct{}, len(peers))
+	for _, p := range peers {
+		online[p.ID()] = struct{}{}
+		_ = d.cfg.Backend.Publish(ctx, d.localLocation(p), true)
+	}
+	for id := range published {
+		if _, ok := online[id]; !ok {
+			loc := Location{ID: id, NodeID: d.cfg.NodeID, NodeName: d.cfg.NodeName}
+			_ = d.cfg.Backend.Publish(ctx, loc, false)
+		}
+	}
+
+	clear(published)
+	for id := range online {
+		published[id] = struct{}{}
+	}
+}
+
+func (d *Directory) localLocation(p Peer) Location {
+	return Location{ID: p.ID(), NodeID: d.cfg.NodeID, NodeName: d.cfg.NodeName}
+}
+
+// NewMemoryDirectory 基于内存的目录存储后端，一般用于测试或者单进程部署。
+func NewMemoryDirectory() DirectoryBackend {
+	return &memoryDirectory{locs: make(map[bson.ObjectID]Location, 64)}
+}
+
+type memoryDirectory struct {
+	mutex sync.RWMutex
+	locs  map[bson.ObjectID]Location
+}
+
+func (md *memoryDirectory) Lookup(_ context.Context, id bson.ObjectID) (Location, error) {
+	md.mutex.RLock()
+	defer md.mutex.RUnlock()
+
+	if loc, ok := md.locs[id]; ok {
+		return loc, nil
+	}
+
+	return Location{}, ErrPeerOffline
+}
+
+func (md *memoryDirectory) Publish(_ context.Context, loc Location, online bool) error {
+	md.mutex.Lock()
+	defer md.mutex.Unlock()
+
+	if online {
+		md.locs[loc.ID] = loc
+	} else if old, ok := md.locs[loc.ID]; ok && old.NodeID == loc.NodeID {
+		delete(md.locs, loc.ID)
+	}
+
+	return nil
+}
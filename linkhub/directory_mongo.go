@@ -0,0 +1,159 @@
+package linkhub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+	"github.com/xmx/aegis-control/datalayer/repository"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// NewMongoDirectory 基于 agent 表 broker 字段的目录存储后端。
+//
+// 查询结果会缓存在内存中，需要运行 Watch 通过 change stream 保持缓存同步。
+// 缓存以 agent 的 updated_at 作为版本，只接受不早于已缓存版本的写入，
+// 避免并发的 Lookup 用旧的查询结果覆盖 change stream 写入的新状态。
+func NewMongoDirectory(repo repository.Agent) *MongoDirectory {
+	return &MongoDirectory{
+		repo: repo,
+		locs: make(map[bson.ObjectID]cachedLocation, 1024),
+	}
+}
+
+type MongoDirectory struct {
+	repo     repository.Agent
+	mutex    sync.RWMutex
+	locs     map[bson.ObjectID]cachedLocation
+	watching bool
+}
+
+// cachedLocation 缓存的节点位置，节点下线后保留 online=false 的记录作为版本。
+type cachedLocation struct {
+	loc       Location
+	online    bool
+	updatedAt time.Time
+}
+
+func (md *MongoDirectory) Lookup(ctx context.Context, id bson.ObjectID) (Location, error) {
+	md.mutex.RLock()
+	ent, ok := md.locs[id]
+	md.mutex.RUnlock()
+	if ok {
+		if !ent.online {
+			return Location{}, ErrPeerOffline
+		}
+		return ent.loc, nil
+	}
+
+	opt := options.FindOne().SetProjection(bson.M{"status": 1, "broker": 1, "updated_at": 1})
+	agt, err := md.repo.FindOne(ctx, bson.M{"_id": id}, opt)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return Location{}, ErrPeerOffline
+		}
+		return Location{}, err
+	}
+	loc, online := md.location(agt)
+
+	// 只有 change stream 在运行时才缓存，否则无法感知节点迁移。
+	md.mutex.Lock()
+	if md.watching {
+		md.store(id, cachedLocation{loc: loc, online: online, updatedAt: agt.UpdatedAt})
+	}
+	md.mutex.Unlock()
+
+	if !online {
+		return Location{}, ErrPeerOffline
+	}
+
+	return loc, nil
+}
+
+func (md *MongoDirectory) Publish(ctx context.Context, loc Location, online bool) error {
+	now := time.Now()
+	if online {
+		broker := &model.AgentConnectedBroker{ID: loc.NodeID, Name: loc.NodeName}
+		update := bson.M{"$set": bson.M{"status": true, "broker": broker, "updated_at": now}}
+		_, err := md.repo.UpdateByID(ctx, loc.ID, update)
+		return err
+	}
+
+	// 只有节点仍然记录在本 node 时才标记下线，避免覆盖节点已经重连到的新 node。
+	filter := bson.M{"_id": loc.ID, "broker.id": loc.NodeID}
+	update := bson.M{"$set": bson.M{"status": false, "updated_at": now}}
+	_, err := md.repo.UpdateOne(ctx, filter, update)
+
+	return err
+}
+
+// Watch 监听 agent 表的变更并同步缓存，直到 ctx 取消或 change stream 出错。
+func (md *MongoDirectory) Watch(ctx context.Context) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": []string{"insert", "update", "replace", "delete"}}}}},
+	}
+	opt := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	stream, err := md.repo.Watch(ctx, pipeline, opt)
+	if err != nil {
+		return err
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer stream.Close(context.Background())
+
+	md.mutex.Lock()
+	md.watching = true
+	md.mutex.Unlock()
+	defer func() {
+		md.mutex.Lock()
+		md.watching = false
+		md.locs = make(map[bson.ObjectID]cachedLocation, 1024)
+		md.mutex.Unlock()
+	}()
+
+	for stream.Next(ctx) {
+		var evt agentChangeEvent
+		if err = stream.Decode(&evt); err != nil {
+			return err
+		}
+
+		id := evt.DocumentKey.ID
+		ent := cachedLocation{updatedAt: time.Now()} // 删除事件没有文档，视为最新状态
+		if agt := evt.FullDocument; agt != nil {
+			ent.loc, ent.online = md.location(agt)
+			ent.updatedAt = agt.UpdatedAt
+		}
+		md.mutex.Lock()
+		md.store(id, ent)
+		md.mutex.Unlock()
+	}
+
+	return stream.Err()
+}
+
+// store 写入缓存，已缓存的版本更新时忽略本次写入，调用方需持有写锁。
+func (md *MongoDirectory) store(id bson.ObjectID, ent cachedLocation) {
+	if old, ok := md.locs[id]; ok && old.updatedAt.After(ent.updatedAt) {
+		return
+	}
+	md.locs[id] = ent
+}
+
+func (*MongoDirectory) location(agt *model.Agent) (Location, bool) {
+	if agt == nil || !agt.Status || agt.Broker == nil || agt.Broker.ID.IsZero() {
+		return Location{}, false
+	}
+
+	return Location{ID: agt.ID, NodeID: agt.Broker.ID, NodeName: agt.Broker.Name}, true
+}
+
+type agentChangeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID bson.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument *model.Agent `bson:"fullDocument"`
+}
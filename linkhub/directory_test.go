@@ -0,0 +1,105 @@
+package linkhub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// gatedDirectory 在 gate 关闭前阻塞所有发布，模拟缓慢的存储后端。
+type gatedDirectory struct {
+	DirectoryBackend
+	entered chan struct{}
+	gate    chan struct{}
+}
+
+func (g *gatedDirectory) Publish(ctx context.Context, loc Location, online bool) error {
+	select {
+	case g.entered <- struct{}{}:
+	default:
+	}
+	<-g.gate
+	return g.DirectoryBackend.Publish(ctx, loc, online)
+}
+
+// TestDirectoryResync 订阅缓冲区溢出丢弃事件后，目录以连接池快照为准重新同步。
+func TestDirectoryResync(t *testing.T) {
+	hub := NewHub("example.com")
+	before := bson.NewObjectID()
+	hub.Put(before, newStubMuxer(1), stubInfo(1), time.Now())
+
+	mem := NewMemoryDirectory()
+	backend := &gatedDirectory{DirectoryBackend: mem, entered: make(chan struct{}, 1), gate: make(chan struct{})}
+	dir := NewDirectory(DirectoryConfig{NodeID: bson.NewObjectID(), NodeName: "broker-1", Local: hub, Backend: backend})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- dir.Run(ctx) }()
+	<-backend.entered
+
+	// 存储后端阻塞期间产生的事件超过订阅缓冲区，最后的上下线事件被丢弃。
+	for i := range 1100 {
+		id := bson.NewObjectID()
+		hub.Put(id, newStubMuxer(i+2), stubInfo(i+2), time.Now())
+		hub.DelID(id)
+	}
+	after := bson.NewObjectID()
+	hub.Put(after, newStubMuxer(2000), stubInfo(2000), time.Now())
+	hub.DelID(before)
+	close(backend.gate)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, errAfter := mem.Lookup(ctx, after)
+		_, errBefore := mem.Lookup(ctx, before)
+		if errAfter == nil && errors.Is(errBefore, ErrPeerOffline) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("directory not resynced: after=%v before=%v", errAfter, errBefore)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() = %v, want context.Canceled", err)
+	}
+}
+
+// TestMongoDirectoryVersion 缓存只接受不早于已缓存版本的写入，下线记录同样作为版本。
+func TestMongoDirectoryVersion(t *testing.T) {
+	md := NewMongoDirectory(nil) // 以下查询都命中缓存，不会访问数据库
+	ctx := context.Background()
+	id := bson.NewObjectID()
+	loc := Location{ID: id, NodeID: bson.NewObjectID(), NodeName: "broker-1"}
+	now := time.Now()
+
+	md.store(id, cachedLocation{loc: loc, online: true, updatedAt: now})
+	// 并发 Lookup 查询到的旧结果不能覆盖 change stream 写入的新状态。
+	md.store(id, cachedLocation{updatedAt: now.Add(-time.Second)})
+	if got, err := md.Lookup(ctx, id); err != nil || got != loc {
+		t.Fatalf("Lookup() = %v, %v, want %v", got, err, loc)
+	}
+
+	md.store(id, cachedLocation{updatedAt: now.Add(time.Second)})
+	if _, err := md.Lookup(ctx, id); !errors.Is(err, ErrPeerOffline) {
+		t.Fatalf("Lookup() after offline = %v, want ErrPeerOffline", err)
+	}
+
+	// 下线记录之后，更早的在线状态同样被忽略。
+	md.store(id, cachedLocation{loc: loc, online: true, updatedAt: now})
+	if _, err := md.Lookup(ctx, id); !errors.Is(err, ErrPeerOffline) {
+		t.Fatalf("Lookup() after stale online = %v, want ErrPeerOffline", err)
+	}
+
+	moved := Location{ID: id, NodeID: bson.NewObjectID(), NodeName: "broker-2"}
+	md.store(id, cachedLocation{loc: moved, online: true, updatedAt: now.Add(2 * time.Second)})
+	if got, err := md.Lookup(ctx, id); err != nil || got != moved {
+		t.Fatalf("Lookup() after move = %v, %v, want %v", got, err, moved)
+	}
+}
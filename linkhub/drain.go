@@ -0,0 +1,98 @@
+package linkhub
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// DrainReport 排空连接池各阶段的统计。
+type DrainReport struct {
+	Total        int       `json:"total"`         // 开始排空时的节点数
+	Notified     int       `json:"notified"`      // 通知成功的节点数
+	NotifyFailed int       `json:"notify_failed"` // 通知失败或超时的节点数
+	Left         int       `json:"left"`          // 等待期间主动离开的节点数
+	Forced       int       `json:"forced"`        // 等待超时后被强制关闭的节点数
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+}
+
+// DrainFunc 通知节点迁移到其它 node 重新连接，需要响应 ctx 的取消。
+type DrainFunc func(ctx context.Context, p Peer) error
+
+// DrainConfig 排空连接池的配置，通知和等待两个阶段分别计时，
+// 通知阶段耗尽时间不会挤占等待节点离开的时间。
+type DrainConfig struct {
+	Notify        DrainFunc     // 通知节点迁移，为 nil 时不通知
+	NotifyTimeout time.Duration // 通知阶段的超时时间，小于等于 0 时只受 ctx 限制
+	WaitTimeout   time.Duration // 等待节点离开的超时时间，小于等于 0 时只受 ctx 限制
+}
+
+// drain 排空连接池：
+//
+//  1. 拒绝新节点加入；
+//  2. 调用 cfg.Notify 通知现有节点迁移，直到 cfg.NotifyTimeout；
+//  3. 等待节点主动离开，直到全部离开或 cfg.WaitTimeout；
+//  4. 强制关闭剩余节点的通道。
+//
+// ctx 限制整个排空过程，ctx 结束后直接进入强制关闭阶段。
+func (c *hubCore) drain(ctx context.Context, hub Huber, cfg DrainConfig) *DrainReport {
+	c.draining.Store(true)
+
+	peers := hub.Peers()
+	report := &DrainReport{Total: len(peers), StartedAt: time.Now()}
+	if cfg.Notify != nil && len(peers) != 0 {
+		nctx, cancel := withOptionalTimeout(ctx, cfg.NotifyTimeout)
+		res, _ := Fanout(nctx, hub, SelectAll(), cfg.Notify, FanoutConfig{Concurrency: 64})
+		cancel()
+		report.Notified = len(res.Succeeded)
+		report.NotifyFailed = res.Total() - report.Notified
+	}
+
+	wctx, cancel := withOptionalTimeout(ctx, cfg.WaitTimeout)
+	defer cancel()
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	remain := peers
+	for {
+		// 节点不在连接池中或已被替换为其它连接即视为已离开。
+		remain = slices.DeleteFunc(remain, func(p Peer) bool {
+			if hub.GetID(p.ID()) != p {
+				report.Left++
+				return true
+			}
+			return false
+		})
+		if len(remain) == 0 {
+			break
+		}
+
+		select {
+		case <-wctx.Done():
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+
+	for _, p := range remain {
+		if hub.DelPeer(p) {
+			_ = p.Muxer().Close()
+			report.Forced++
+		} else {
+			report.Left++
+		}
+	}
+	report.FinishedAt = time.Now()
+
+	return report
+}
+
+func withOptionalTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, d)
+}
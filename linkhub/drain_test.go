@@ -0,0 +1,48 @@
+package linkhub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestDrain(t *testing.T) {
+	for _, bc := range hubCases {
+		t.Run(bc.name, func(t *testing.T) {
+			hub := NewHub("example.com", bc.opts...)
+			for i := range 10 {
+				hub.Put(bson.NewObjectID(), newStubMuxer(i), stubInfo(i), time.Time{})
+			}
+
+			// 一半节点收到通知后离开，另一半卡住直到通知阶段超时。
+			cfg := DrainConfig{
+				Notify: func(ctx context.Context, p Peer) error {
+					if p.Info().Semver[2]%2 == 0 {
+						hub.DelPeer(p)
+						return nil
+					}
+					<-ctx.Done()
+					return ctx.Err()
+				},
+				NotifyTimeout: 50 * time.Millisecond,
+				WaitTimeout:   300 * time.Millisecond,
+			}
+			report := hub.Drain(context.Background(), cfg)
+			if report.Total != 10 || report.Notified != 5 || report.NotifyFailed != 5 {
+				t.Fatalf("notify report = %+v", report)
+			}
+			if report.Left != 5 || report.Forced != 5 {
+				t.Fatalf("left = %d forced = %d, want 5 and 5", report.Left, report.Forced)
+			}
+			// 通知阶段超时不能挤占等待阶段。
+			if d := report.FinishedAt.Sub(report.StartedAt); d < 300*time.Millisecond {
+				t.Fatalf("drain finished in %s, want at least the wait timeout", d)
+			}
+			if n := len(hub.Peers()); n != 0 {
+				t.Fatalf("%d peers remain after drain", n)
+			}
+		})
+	}
+}
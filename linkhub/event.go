@@ -0,0 +1,172 @@
+package linkhub
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type EventType int
+
+const (
+	EventJoin    EventType = iota + 1 // 节点上线
+	EventLeave                        // 节点下线
+	EventReplace                      // 同 ID 的节点被新连接替换
+	EventMigrate                      // 节点的连接迁移到了新的远端地址，节点保持不变
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventJoin:
+		return "join"
+	case EventLeave:
+		return "leave"
+	case EventReplace:
+		return "replace"
+	case EventMigrate:
+		return "migrate"
+	default:
+		return "unknown"
+	}
+}
+
+// Event 节点变更事件。
+type Event struct {
+	Type EventType
+	Peer Peer   // 事件节点，EventReplace 时为新节点。
+	Info Info   // 事件节点信息。
+	Old  Peer   // EventReplace 时为被替换的旧节点，其它事件为 nil。
+	From string // EventMigrate 时为迁移前的远端地址。
+	To   string // EventMigrate 时为迁移后的远端地址。
+	At   time.Time
+}
+
+// DropPolicy 订阅缓冲区满时的丢弃策略。
+type DropPolicy int
+
+const (
+	DropNewest DropPolicy = iota // 丢弃新产生的事件
+	DropOldest                   // 丢弃缓冲区中最旧的事件
+)
+
+type Subscription interface {
+	// C 事件通道，取消订阅后会被关闭。
+	C() <-chan Event
+
+	// Dropped 因缓冲区满被丢弃的事件数。
+	Dropped() uint64
+
+	// Close 取消订阅。
+	Close()
+}
+
+// eventBus 事件总线。订阅者列表写时复制，发布时不需要获取总线的锁，
+// 各分片可以并发发布事件。
+type eventBus struct {
+	mutex sync.Mutex                    // 串行化订阅和取消订阅
+	subs  atomic.Pointer[[]*subscriber] // 当前的订阅者列表，只读
+}
+
+func (b *eventBus) subscribe(size int, drop DropPolicy) Subscription {
+	if size <= 0 {
+		size = 64
+	}
+	sub := &subscriber{
+		bus:  b,
+		ch:   make(chan Event, size),
+		drop: drop,
+	}
+
+	b.mutex.Lock()
+	var subs []*subscriber
+	if old := b.subs.Load(); old != nil {
+		subs = append(subs, *old...)
+	}
+	subs = append(subs, sub)
+	b.subs.Store(&subs)
+	b.mutex.Unlock()
+
+	return sub
+}
+
+// publish 向所有订阅者投递事件，该方法不会阻塞。
+func (b *eventBus) publish(evt Event) {
+	if evt.At.IsZero() {
+		evt.At = time.Now()
+	}
+
+	subs := b.subs.Load()
+	if subs == nil {
+		return
+	}
+	for _, sub := range *subs {
+		sub.send(evt)
+	}
+}
+
+func (b *eventBus) unsubscribe(sub *subscriber) {
+	b.mutex.Lock()
+	if old := b.subs.Load(); old != nil {
+		subs := make([]*subscriber, 0, len(*old))
+		for _, s := range *old {
+			if s != sub {
+				subs = append(subs, s)
+			}
+		}
+		b.subs.Store(&subs)
+	}
+	b.mutex.Unlock()
+
+	// 正在发布的协程可能还持有旧的订阅者列表，关闭通道前需要等待其发送完毕。
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	if !sub.closed {
+		sub.closed = true
+		close(sub.ch)
+	}
+}
+
+type subscriber struct {
+	bus     *eventBus
+	ch      chan Event
+	drop    DropPolicy
+	dropped atomic.Uint64
+	mutex   sync.RWMutex // 发送时持有读锁，关闭通道时持有写锁
+	closed  bool
+}
+
+func (s *subscriber) C() <-chan Event { return s.ch }
+func (s *subscriber) Dropped() uint64 { return s.dropped.Load() }
+func (s *subscriber) Close()          { s.bus.unsubscribe(s) }
+
+// send 投递事件，多个分片可以并发投递。
+func (s *subscriber) send(evt Event) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.ch <- evt:
+		return
+	default:
+	}
+
+	if s.drop == DropNewest {
+		s.dropped.Add(1)
+		return
+	}
+
+	// 丢弃最旧的事件腾出位置，消费者可能同时在读取，所以再次尝试仍需非阻塞。
+	select {
+	case <-s.ch:
+		s.dropped.Add(1)
+	default:
+	}
+	select {
+	case s.ch <- evt:
+	default:
+		s.dropped.Add(1)
+	}
+}
@@ -0,0 +1,217 @@
+package linkhub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Selector 从连接池中选出需要执行的节点，offline 为指定了但是不在线的节点 ID。
+type Selector func(hub Huber) (peers []Peer, offline []bson.ObjectID, err error)
+
+// SelectAll 选择全部在线节点。
+func SelectAll() Selector {
+	return func(hub Huber) ([]Peer, []bson.ObjectID, error) {
+		return hub.Peers(), nil, nil
+	}
+}
+
+// SelectQuery 选择满足查询条件的节点。
+func SelectQuery(q Query) Selector {
+	return func(hub Huber) ([]Peer, []bson.ObjectID, error) {
+		peers, err := hub.Find(q)
+		return peers, nil, err
+	}
+}
+
+// SelectIDs 选择指定 ID 的节点，不在线的节点会被记为跳过。
+func SelectIDs(ids ...bson.ObjectID) Selector {
+	return func(hub Huber) ([]Peer, []bson.ObjectID, error) {
+		peers := make([]Peer, 0, len(ids))
+		var offline []bson.ObjectID
+		uniq := make(map[bson.ObjectID]struct{}, len(ids))
+		for _, id := range ids {
+			if _, exists := uniq[id]; exists {
+				continue
+			}
+			uniq[id] = struct{}{}
+
+			if p := hub.GetID(id); p != nil {
+				peers = append(peers, p)
+			} else {
+				offline = append(offline, id)
+			}
+		}
+
+		return peers, offline, nil
+	}
+}
+
+// SelectFunc 选择 fn 返回 true 的节点。
+func SelectFunc(fn func(Peer) bool) Selector {
+	return func(hub Huber) ([]Peer, []bson.ObjectID, error) {
+		var peers []Peer
+		for _, p := range hub.Peers() {
+			if fn(p) {
+				peers = append(peers, p)
+			}
+		}
+
+		return peers, nil, nil
+	}
+}
+
+type FanoutStatus int
+
+const (
+	FanoutSucceeded FanoutStatus = iota + 1 // 执行成功
+	FanoutFailed                            // 执行出错
+	FanoutTimeout                           // 执行超时
+	FanoutSkipped                           // 未执行：节点不在线或任务已取消
+)
+
+func (s FanoutStatus) String() string {
+	switch s {
+	case FanoutSucceeded:
+		return "succeeded"
+	case FanoutFailed:
+		return "failed"
+	case FanoutTimeout:
+		return "timeout"
+	case FanoutSkipped:
+		return "skipped"
+	default:
+		return "unknown"
+	}
+}
+
+func (s FanoutStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// FanoutConfig 批量执行配置。
+type FanoutConfig struct {
+	Concurrency int           // 最大并发数，小于等于 0 时默认为 16
+	Timeout     time.Duration // 单个节点的执行超时时间，小于等于 0 时不限制
+}
+
+// FanoutResult 单个节点的执行结果。
+type FanoutResult struct {
+	ID         bson.ObjectID `json:"id"`
+	Host       string        `json:"host,omitzero"`
+	Status     FanoutStatus  `json:"status"`
+	Error      string        `json:"error,omitzero"`
+	StartedAt  time.Time     `json:"started_at,omitzero"`
+	FinishedAt time.Time     `json:"finished_at,omitzero"`
+	Err        error         `json:"-"`
+}
+
+// FanoutReport 批量执行结果，按照执行状态分组。
+type FanoutReport struct {
+	Succeeded  []*FanoutResult `json:"succeeded"`
+	Failed     []*FanoutResult `json:"failed"`
+	Timeout    []*FanoutResult `json:"timeout"`
+	Skipped    []*FanoutResult `json:"skipped"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+}
+
+// Total 结果总数。
+func (r *FanoutReport) Total() int {
+	return len(r.Succeeded) + len(r.Failed) + len(r.Timeout) + len(r.Skipped)
+}
+
+func (r *FanoutReport) add(res *FanoutResult) {
+	switch res.Status {
+	case FanoutSucceeded:
+		r.Succeeded = append(r.Succeeded, res)
+	case FanoutTimeout:
+		r.Timeout = append(r.Timeout, res)
+	case FanoutSkipped:
+		r.Skipped = append(r.Skipped, res)
+	default:
+		r.Failed = append(r.Failed, res)
+	}
+}
+
+// Fanout 对选中的节点并发执行 fn，并发数和单节点超时由 cfg 控制。
+//
+// fn 需要响应 ctx 的取消，否则超时的节点仍会等待 fn 返回。
+// ctx 取消后尚未开始执行的节点会被记为跳过。
+func Fanout(ctx context.Context, hub Huber, sel Selector, fn func(ctx context.Context, p Peer) error, cfg FanoutConfig) (*FanoutReport, error) {
+	report := &FanoutReport{StartedAt: time.Now()}
+	peers, offline, err := sel(hub)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range offline {
+		report.add(&FanoutResult{ID: id, Status: FanoutSkipped, Error: "peer is offline"})
+	}
+
+	limit := cfg.Concurrency
+	if limit <= 0 {
+		limit = 16
+	}
+	sem := make(chan struct{}, limit)
+	results := make(chan *FanoutResult, len(peers))
+
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if exx := ctx.Err(); exx != nil {
+			for _, rest := range peers[i:] {
+				results <- &FanoutResult{ID: rest.ID(), Host: rest.Host(), Status: FanoutSkipped, Error: exx.Error(), Err: exx}
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results <- fanoutCall(ctx, p, fn, cfg.Timeout)
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	for res := range results {
+		report.add(res)
+	}
+	report.FinishedAt = time.Now()
+
+	return report, nil
+}
+
+func fanoutCall(parent context.Context, p Peer, fn func(context.Context, Peer) error, timeout time.Duration) *FanoutResult {
+	ctx, cancel := parent, context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, timeout)
+	}
+	defer cancel()
+
+	res := &FanoutResult{ID: p.ID(), Host: p.Host(), StartedAt: time.Now()}
+	err := fn(ctx, p)
+	res.FinishedAt = time.Now()
+	if err == nil {
+		res.Status = FanoutSucceeded
+		return res
+	}
+
+	res.Err, res.Error = err, err.Error()
+	if parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		res.Status = FanoutTimeout
+	} else {
+		res.Status = FanoutFailed
+	}
+
+	return res
+}
@@ -0,0 +1,168 @@
+package linkhub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// fanoutHub 创建包含 n 个节点的连接池。
+func fanoutHub(n int) (Huber, []Peer) {
+	hub := NewHub("example.com")
+	peers := make([]Peer, 0, n)
+	for i := range n {
+		p, _ := hub.Put(bson.NewObjectID(), newStubMuxer(i), stubInfo(i), time.Time{})
+		peers = append(peers, p)
+	}
+
+	return hub, peers
+}
+
+func TestFanoutConcurrency(t *testing.T) {
+	hub, peers := fanoutHub(40)
+	const limit = 4
+
+	var active, peak atomic.Int64
+	fn := func(context.Context, Peer) error {
+		n := active.Add(1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		active.Add(-1)
+		return nil
+	}
+	report, err := Fanout(context.Background(), hub, SelectAll(), fn, FanoutConfig{Concurrency: limit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := peak.Load(); n > limit {
+		t.Fatalf("peak concurrency = %d, want <= %d", n, limit)
+	}
+	if len(report.Succeeded) != len(peers) || report.Total() != len(peers) {
+		t.Fatalf("succeeded %d of %d", len(report.Succeeded), report.Total())
+	}
+}
+
+func TestFanoutTimeout(t *testing.T) {
+	hub, peers := fanoutHub(6)
+	slow := make(map[bson.ObjectID]bool)
+	for _, p := range peers[:3] {
+		slow[p.ID()] = true
+	}
+
+	fn := func(ctx context.Context, p Peer) error {
+		if !slow[p.ID()] {
+			return nil
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	start := time.Now()
+	report, err := Fanout(context.Background(), hub, SelectAll(), fn, FanoutConfig{Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("fanout took %s, per-peer timeout not applied", d)
+	}
+	if len(report.Timeout) != 3 || len(report.Succeeded) != 3 {
+		t.Fatalf("timeout %d succeeded %d, want 3 and 3", len(report.Timeout), len(report.Succeeded))
+	}
+	for _, res := range report.Timeout {
+		if !slow[res.ID] || !errors.Is(res.Err, context.DeadlineExceeded) {
+			t.Fatalf("unexpected timeout result %+v", res)
+		}
+		if res.FinishedAt.Sub(res.StartedAt) < 20*time.Millisecond {
+			t.Fatalf("peer %s timed out after %s", res.Host, res.FinishedAt.Sub(res.StartedAt))
+		}
+	}
+}
+
+func TestFanoutReport(t *testing.T) {
+	hub, peers := fanoutHub(4)
+	offline := bson.NewObjectID()
+	boom := errors.New("boom")
+
+	ids := []bson.ObjectID{offline}
+	for _, p := range peers {
+		ids = append(ids, p.ID(), p.ID()) // 重复的 ID 只执行一次
+	}
+	fn := func(ctx context.Context, p Peer) error {
+		switch p {
+		case peers[0]:
+			return boom
+		case peers[1]:
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+	report, err := Fanout(context.Background(), hub, SelectIDs(ids...), fn, FanoutConfig{Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total() != len(peers)+1 {
+		t.Fatalf("Total() = %d, want %d", report.Total(), len(peers)+1)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0].ID != offline {
+		t.Fatalf("skipped = %+v, want the offline peer", report.Skipped)
+	}
+	if len(report.Failed) != 1 || !errors.Is(report.Failed[0].Err, boom) || report.Failed[0].Error != "boom" {
+		t.Fatalf("failed = %+v", report.Failed)
+	}
+	if len(report.Timeout) != 1 || report.Timeout[0].ID != peers[1].ID() {
+		t.Fatalf("timeout = %+v", report.Timeout)
+	}
+	if len(report.Succeeded) != 2 {
+		t.Fatalf("succeeded = %d, want 2", len(report.Succeeded))
+	}
+	if report.FinishedAt.Before(report.StartedAt) {
+		t.Fatal("report finished before it started")
+	}
+
+	raw, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), `"status":"failed"`) || !strings.Contains(string(raw), `"status":"timeout"`) {
+		t.Fatalf("status not marshaled as text: %s", raw)
+	}
+}
+
+// TestFanoutCanceled 整体取消后尚未开始的节点记为跳过，执行中的节点记为失败而不是超时。
+func TestFanoutCanceled(t *testing.T) {
+	hub, peers := fanoutHub(8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls atomic.Int64
+	fn := func(ctx context.Context, _ Peer) error {
+		if calls.Add(1) == 1 {
+			cancel()
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	report, err := Fanout(ctx, hub, SelectAll(), fn, FanoutConfig{Concurrency: 1, Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failed) != 1 || len(report.Skipped) != len(peers)-1 || len(report.Timeout) != 0 {
+		t.Fatalf("failed %d skipped %d timeout %d", len(report.Failed), len(report.Skipped), len(report.Timeout))
+	}
+	for _, res := range report.Skipped {
+		if !errors.Is(res.Err, context.Canceled) {
+			t.Fatalf("skipped result error = %v", res.Err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package linkhub
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/xmx/aegis-common/problem"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// NewForwardHandler 节点转发服务，挂载在 node（例如 broker）的 HTTP 服务上，
+// 接收 CONNECT 请求并将流转发到本地连接池中的节点，请求的 Host 为节点 ID。
+//
+// 对端通过 DialForward 发起转发。authorize 校验调用方身份，返回错误时拒绝转发，
+// 例如只允许通过 mTLS 认证的 broker 转发的 quick.RequireBroker；为 nil 时拒绝所有转发。
+func NewForwardHandler(hub Huber, authorize func(*http.Request) error) http.Handler {
+	return &forwardHandler{hub: hub, authorize: authorize}
+}
+
+type forwardHandler struct {
+	hub       Huber
+	authorize func(*http.Request) error
+}
+
+func (fh *forwardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		fh.writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if fh.authorize == nil {
+		fh.writeError(w, r, http.StatusForbidden, "forward authorization is not configured")
+		return
+	}
+	if err := fh.authorize(r); err != nil {
+		fh.writeError(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	id, err := bson.ObjectIDFromHex(host)
+	if err != nil {
+		fh.writeError(w, r, http.StatusBadRequest, "invalid peer id: "+host)
+		return
+	}
+	peer := fh.hub.GetID(id)
+	if peer == nil {
+		fh.writeError(w, r, http.StatusNotFound, "peer is offline: "+host)
+		return
+	}
+	stream, err := peer.Muxer().Open(r.Context())
+	if err != nil {
+		fh.writeError(w, r, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		_ = stream.Close()
+		fh.writeError(w, r, http.StatusInternalServerError, "hijack not supported")
+		return
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		_ = stream.Close()
+		return
+	}
+	if _, err = io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		_ = stream.Close()
+		_ = conn.Close()
+		return
+	}
+
+	var src io.Reader = conn
+	if n := brw.Reader.Buffered(); n > 0 {
+		src = io.MultiReader(brw.Reader, conn)
+	}
+	splice(stream, conn, src)
+}
+
+func (fh *forwardHandler) writeError(w http.ResponseWriter, r *http.Request, code int, detail string) {
+	pd := &problem.Details{
+		Host:     r.Host,
+		Title:    "节点转发失败",
+		Status:   code,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Method:   r.Method,
+		Datetime: time.Now().UTC(),
+	}
+	_ = pd.JSON(w)
+}
+
+// DialForward 通过 node 的通道请求转发到 id 节点，node 一侧需要挂载 NewForwardHandler。
+func DialForward(ctx context.Context, node Peer, id bson.ObjectID) (net.Conn, error) {
+	conn, err := node.Muxer().Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	authority := id.Hex()
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: authority},
+		Host:   authority,
+		Header: make(http.Header),
+	}
+	if err = req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		_ = conn.Close()
+		raw, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, &ForwardError{ID: id, Node: node.ID(), StatusCode: res.StatusCode, Body: raw}
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	if br.Buffered() == 0 {
+		return conn, nil
+	}
+
+	return &bufferedConn{Conn: conn, rd: io.MultiReader(br, conn)}, nil
+}
+
+type ForwardError struct {
+	ID         bson.ObjectID
+	Node       bson.ObjectID
+	StatusCode int
+	Body       []byte
+}
+
+func (e *ForwardError) Error() string {
+	return "forward to peer " + e.ID.Hex() + " via node " + e.Node.Hex() +
+		" failed, status=" + http.StatusText(e.StatusCode) + ", body='" + string(e.Body) + "'"
+}
+
+type bufferedConn struct {
+	net.Conn
+	rd io.Reader
+}
+
+func (bc *bufferedConn) Read(p []byte) (int, error) {
+	return bc.rd.Read(p)
+}
+
+// splice 双向拷贝数据，任意一方结束后关闭两端连接。
+func splice(a, b net.Conn, bsrc io.Reader) {
+	var once sync.Once
+	closeAll := func() {
+		_ = a.Close()
+		_ = b.Close()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(a, bsrc)
+		once.Do(closeAll)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(b, a)
+		once.Do(closeAll)
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,90 @@
+package linkhub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// dialMuxer 每次 Open 都拨号到 addr，模拟到 node 转发服务的通道。
+type dialMuxer struct {
+	stubMuxer
+	addr string
+}
+
+func (m dialMuxer) Open(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", m.addr)
+}
+
+func TestForward(t *testing.T) {
+	errDenied := errors.New("caller is not a broker")
+	cases := []struct {
+		name      string
+		authorize func(*http.Request) error
+		status    int
+	}{
+		{name: "allowed", authorize: func(*http.Request) error { return nil }},
+		{name: "denied", authorize: func(*http.Request) error { return errDenied }, status: http.StatusForbidden},
+		{name: "unconfigured", status: http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// 目标节点连接在 node 的本地连接池中，节点一侧回显收到的数据。
+			local := NewHub("example.com")
+			srv, cli := pipeMuxers(t)
+			go serveEcho(cli)
+			target := bson.NewObjectID()
+			local.Put(target, srv, stubInfo(1), time.Now())
+
+			hs := httptest.NewServer(NewForwardHandler(local, tc.authorize))
+			defer hs.Close()
+			nodes := NewHub("example.com")
+			node, _ := nodes.Put(bson.NewObjectID(), dialMuxer{stubMuxer: newStubMuxer(2), addr: hs.Listener.Addr().String()}, stubInfo(2), time.Now())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := DialForward(ctx, node, target)
+			if tc.status != 0 {
+				var fe *ForwardError
+				if !errors.As(err, &fe) || fe.StatusCode != tc.status {
+					t.Fatalf("DialForward() = %v, want status %d", err, tc.status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			//goland:noinspection GoUnhandledErrorResult
+			defer conn.Close()
+
+			want := []byte("hello through the broker")
+			if _, err = conn.Write(want); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]byte, len(want))
+			if _, err = io.ReadFull(conn, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("echo = %q, want %q", got, want)
+			}
+
+			// 不在线的节点返回 404，不会打开流。
+			if _, err = DialForward(ctx, node, bson.NewObjectID()); err == nil {
+				t.Fatal("forward to offline peer succeeded")
+			} else if fe := (*ForwardError)(nil); !errors.As(err, &fe) || fe.StatusCode != http.StatusNotFound {
+				t.Fatalf("DialForward(offline) = %v, want 404", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package linkhub
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xmx/metrics"
+)
+
+// DefaultHeartbeatPath 节点响应心跳的默认路径。
+const DefaultHeartbeatPath = "/api/tunnel/heartbeat"
+
+// Pinger 向节点发送一次心跳，返回错误视为心跳丢失。
+type Pinger func(ctx context.Context, p Peer) error
+
+// StreamPinger 每次心跳都在节点的通道上打开一条独立的流并发送 HTTP 请求，
+// 节点一侧需要在 path 上挂载 NewHeartbeatHandler。
+//
+// 独立的流不会复用连接池中的流，能够发现底层连接静默卡死的情况。
+func StreamPinger(path string) Pinger {
+	if path == "" {
+		path = DefaultHeartbeatPath
+	}
+
+	return func(ctx context.Context, p Peer) error {
+		conn, err := p.Muxer().Open(ctx)
+		if err != nil {
+			return err
+		}
+		//goland:noinspection GoUnhandledErrorResult
+		defer conn.Close()
+
+		if dl, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(dl)
+		}
+		stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+		defer stop()
+
+		req := &http.Request{
+			Method: http.MethodGet,
+			URL:    &url.URL{Scheme: "http", Host: p.Host(), Path: path},
+			Host:   p.Host(),
+			Header: make(http.Header),
+			Close:  true,
+		}
+		if err = req.Write(conn); err != nil {
+			return err
+		}
+		res, err := http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+		_ = res.Body.Close()
+
+		if res.StatusCode/100 != 2 {
+			return &HeartbeatError{Host: p.Host(), StatusCode: res.StatusCode}
+		}
+
+		return nil
+	}
+}
+
+// NewHeartbeatHandler 节点一侧响应心跳的 http.Handler。
+func NewHeartbeatHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+type HeartbeatError struct {
+	Host       string
+	StatusCode int
+}
+
+func (e *HeartbeatError) Error() string {
+	return "heartbeat to " + e.Host + " failed, status=" + strconv.Itoa(e.StatusCode)
+}
+
+// HeartbeatConfig 心跳检测配置。
+type HeartbeatConfig struct {
+	Interval    time.Duration // 心跳间隔，小于等于 0 时默认为 30s
+	Timeout     time.Duration // 单次心跳超时，小于等于 0 时默认为 10s
+	MaxMissed   int           // 连续丢失多少次心跳后驱逐节点，小于等于 0 时默认为 3
+	Concurrency int           // 最大并发数，小于等于 0 时默认为 64
+	Pinger      Pinger        // 心跳方式，为 nil 时使用 StreamPinger(DefaultHeartbeatPath)
+	Metrics     *metrics.Set  // 指标注册位置，为 nil 时使用 metrics.GetDefaultSet()
+}
+
+// NewHeartbeat 心跳检测器，周期性地向连接池中的节点发送心跳并记录 Peer.Keepalive，
+// 连续丢失 MaxMissed 次心跳的节点会被移出连接池并断开连接。
+func NewHeartbeat(hub Huber, cfg HeartbeatConfig, log *slog.Logger) *Heartbeat {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.MaxMissed <= 0 {
+		cfg.MaxMissed = 3
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 64
+	}
+	if cfg.Pinger == nil {
+		cfg.Pinger = StreamPinger(DefaultHeartbeatPath)
+	}
+	set := cfg.Metrics
+	if set == nil {
+		set = metrics.GetDefaultSet()
+	}
+	label := `{domain="` + hub.Domain() + `"}`
+
+	return &Heartbeat{
+		hub:       hub,
+		cfg:       cfg,
+		log:       log,
+		missed:    make(map[Peer]int, 64),
+		latency:   set.GetOrCreateHistogram("linkhub_heartbeat_latency_seconds" + label),
+		failures:  set.GetOrCreateCounter("linkhub_heartbeat_failures_total" + label),
+		evictions: set.GetOrCreateCounter("linkhub_heartbeat_evictions_total" + label),
+	}
+}
+
+type Heartbeat struct {
+	hub       Huber
+	cfg       HeartbeatConfig
+	log       *slog.Logger
+	mutex     sync.Mutex
+	missed    map[Peer]int // 节点连续丢失的心跳次数
+	latency   *metrics.Histogram
+	failures  *metrics.Counter
+	evictions *metrics.Counter
+}
+
+// Run 按照 Interval 周期性地检测，直到 ctx 取消。
+func (hb *Heartbeat) Run(ctx context.Context) error {
+	ticker := time.NewTicker(hb.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := hb.Check(ctx); err != nil {
+				hb.log.Warn("节点心跳检测出错", "error", err)
+			}
+		}
+	}
+}
+
+// Check 对所有在线节点执行一轮心跳检测，返回被驱逐的节点。
+func (hb *Heartbeat) Check(parent context.Context) ([]Peer, error) {
+	var mutex sync.Mutex
+	outcomes := make(map[Peer]error, 64)
+	fn := func(ctx context.Context, p Peer) error {
+		start := time.Now()
+		err := hb.cfg.Pinger(ctx, p)
+		if err == nil {
+			rtt := time.Since(start)
+			p.Keepalive(time.Now(), rtt)
+			hb.latency.Update(rtt.Seconds())
+			hb.hub.Migrate(p) // 心跳成功说明新的路径可用，顺带记录连接迁移。
+		} else if parent.Err() != nil {
+			return err // 检测被取消，不计入丢失次数。
+		}
+
+		mutex.Lock()
+		outcomes[p] = err
+		mutex.Unlock()
+
+		return err
+	}
+	cfg := FanoutConfig{Concurrency: hb.cfg.Concurrency, Timeout: hb.cfg.Timeout}
+	if _, err := Fanout(parent, hb.hub, SelectAll(), fn, cfg); err != nil {
+		return nil, err
+	}
+
+	var evicts []Peer
+	hb.mutex.Lock()
+	missed := make(map[Peer]int, len(outcomes))
+	for p, err := range outcomes {
+		if err == nil {
+			continue
+		}
+		hb.failures.Inc()
+		n := hb.missed[p] + 1
+		if n < hb.cfg.MaxMissed {
+			missed[p] = n
+			continue
+		}
+		evicts = append(evicts, p)
+		hb.log.Warn("节点心跳连续丢失，即将驱逐", "id", p.ID(), "host", p.Host(), "missed", n, "error", err)
+	}
+	// 本轮未执行的节点（例如 ctx 已取消）保持原有计数，已离线的节点不再记录。
+	for p, n := range hb.missed {
+		if _, exists := outcomes[p]; !exists && hb.hub.GetID(p.ID()) == p {
+			missed[p] = n
+		}
+	}
+	hb.missed = missed
+	hb.mutex.Unlock()
+
+	for _, p := range evicts {
+		if hb.hub.DelPeer(p) {
+			hb.evictions.Inc()
+		}
+		_ = p.Muxer().Close()
+	}
+
+	return evicts, nil
+}
+
+// Missed 节点当前连续丢失的心跳次数。
+func (hb *Heartbeat) Missed(p Peer) int {
+	hb.mutex.Lock()
+	defer hb.mutex.Unlock()
+
+	return hb.missed[p]
+}
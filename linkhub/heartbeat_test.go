@@ -0,0 +1,216 @@
+package linkhub
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xmx/metrics"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// flakyPinger 按照节点 ID 控制心跳是否成功。
+type flakyPinger struct {
+	mutex sync.Mutex
+	fails map[bson.ObjectID]bool
+}
+
+func newFlakyPinger() *flakyPinger {
+	return &flakyPinger{fails: make(map[bson.ObjectID]bool)}
+}
+
+func (fp *flakyPinger) fail(id bson.ObjectID, fail bool) {
+	fp.mutex.Lock()
+	fp.fails[id] = fail
+	fp.mutex.Unlock()
+}
+
+func (fp *flakyPinger) ping(_ context.Context, p Peer) error {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+
+	if fp.fails[p.ID()] {
+		return errors.New("ping lost")
+	}
+
+	return nil
+}
+
+func newTestHeartbeat(hub Huber, cfg HeartbeatConfig) *Heartbeat {
+	cfg.Metrics = metrics.NewSet()
+	return NewHeartbeat(hub, cfg, slog.New(slog.DiscardHandler))
+}
+
+func TestHeartbeatEvict(t *testing.T) {
+	hub := NewHub("example.com")
+	bad, _ := hub.Put(bson.NewObjectID(), newStubMuxer(1), stubInfo(1), time.Time{})
+	good, _ := hub.Put(bson.NewObjectID(), newStubMuxer(2), stubInfo(2), time.Time{})
+
+	fp := newFlakyPinger()
+	fp.fail(bad.ID(), true)
+	hb := newTestHeartbeat(hub, HeartbeatConfig{MaxMissed: 3, Pinger: fp.ping})
+	ctx := context.Background()
+
+	for round := 1; round < 3; round++ {
+		evicts, err := hb.Check(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(evicts) != 0 {
+			t.Fatalf("round %d evicted %d peers before MaxMissed", round, len(evicts))
+		}
+		if n := hb.Missed(bad); n != round {
+			t.Fatalf("round %d missed = %d", round, n)
+		}
+	}
+
+	evicts, err := hb.Check(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evicts) != 1 || evicts[0] != bad {
+		t.Fatalf("evicts = %v, want the failing peer", evicts)
+	}
+	if hub.GetID(bad.ID()) != nil {
+		t.Fatal("evicted peer is still in the hub")
+	}
+	if hub.GetID(good.ID()) != good || hb.Missed(good) != 0 {
+		t.Fatal("healthy peer was affected")
+	}
+	if good.Stat().KeepaliveAt.IsZero() {
+		t.Fatal("keepalive not recorded for healthy peer")
+	}
+	if hb.Missed(bad) != 0 {
+		t.Fatal("miss count kept for evicted peer")
+	}
+	if n := hb.evictions.Get(); n != 1 {
+		t.Fatalf("evictions = %d, want 1", n)
+	}
+	if n := hb.failures.Get(); n != 3 {
+		t.Fatalf("failures = %d, want 3", n)
+	}
+}
+
+func TestHeartbeatReset(t *testing.T) {
+	hub := NewHub("example.com")
+	p, _ := hub.Put(bson.NewObjectID(), newStubMuxer(1), stubInfo(1), time.Time{})
+
+	fp := newFlakyPinger()
+	hb := newTestHeartbeat(hub, HeartbeatConfig{MaxMissed: 3, Pinger: fp.ping})
+	ctx := context.Background()
+
+	// 丢失两次后成功一次，计数清零，之后需要重新连续丢失 MaxMissed 次才会驱逐。
+	steps := []struct {
+		fail   bool
+		missed int
+	}{
+		{true, 1}, {true, 2}, {false, 0}, {true, 1}, {true, 2},
+	}
+	for i, st := range steps {
+		fp.fail(p.ID(), st.fail)
+		evicts, err := hb.Check(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(evicts) != 0 {
+			t.Fatalf("step %d evicted peer", i)
+		}
+		if n := hb.Missed(p); n != st.missed {
+			t.Fatalf("step %d missed = %d, want %d", i, n, st.missed)
+		}
+	}
+	if hub.GetID(p.ID()) != p {
+		t.Fatal("peer removed from hub")
+	}
+}
+
+func TestHeartbeatRun(t *testing.T) {
+	hub := NewHub("example.com")
+	hub.Put(bson.NewObjectID(), newStubMuxer(1), stubInfo(1), time.Time{})
+
+	var calls atomic.Int64
+	pinged := make(chan struct{})
+	pinger := func(context.Context, Peer) error {
+		if calls.Add(1) == 1 {
+			close(pinged)
+		}
+		return nil
+	}
+	hb := newTestHeartbeat(hub, HeartbeatConfig{Interval: 10 * time.Millisecond, Pinger: pinger})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- hb.Run(ctx) }()
+
+	select {
+	case <-pinged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("heartbeat never ran")
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+
+	n := calls.Load()
+	time.Sleep(50 * time.Millisecond)
+	if calls.Load() != n {
+		t.Fatal("heartbeat kept running after Run returned")
+	}
+}
+
+// TestHeartbeatCanceled 检测被取消时不计入丢失次数。
+func TestHeartbeatCanceled(t *testing.T) {
+	hub := NewHub("example.com")
+	p, _ := hub.Put(bson.NewObjectID(), newStubMuxer(1), stubInfo(1), time.Time{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pinger := func(ctx context.Context, _ Peer) error {
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	hb := newTestHeartbeat(hub, HeartbeatConfig{MaxMissed: 1, Pinger: pinger})
+
+	evicts, _ := hb.Check(ctx)
+	if len(evicts) != 0 || hb.Missed(p) != 0 || hub.GetID(p.ID()) != p {
+		t.Fatal("canceled check counted as a miss")
+	}
+}
+
+func TestStreamPinger(t *testing.T) {
+	hub := NewHub("example.com")
+	srv, cli := pipeMuxers(t)
+
+	mux := http.NewServeMux()
+	mux.Handle(DefaultHeartbeatPath, NewHeartbeatHandler())
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	go func() { _ = http.Serve(srv, mux) }()
+
+	p, _ := hub.Put(bson.NewObjectID(), cli, stubInfo(1), time.Time{})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := StreamPinger("")(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	err := StreamPinger("/broken")(ctx, p)
+	var he *HeartbeatError
+	if !errors.As(err, &he) || he.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("err = %v, want HeartbeatError 500", err)
+	}
+}
@@ -0,0 +1,299 @@
+package linkhub
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+	"github.com/xmx/aegis-control/datalayer/repository"
+	"github.com/xmx/metrics"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// HistoryHooker 将节点上下线记录写入数据库的回调，见 NewAgentHistory 和 NewBrokerHistory。
+type HistoryHooker interface {
+	ServerHooker
+	MigrateHooker
+
+	// Close 不再接受新的写入，等待已投递的写入完成，ctx 取消时中止剩余的写入并返回 ctx 的错误。
+	Close(ctx context.Context) error
+}
+
+// NewAgentHistory 将 agent 上下线记录写入 agent_connect_history 的回调。
+//
+// 上线时插入记录，下线时补充下线时间、在线时长和流量。agent 的 Info.Name 为其机器码。
+// 数据库写入由后台协程异步完成，不阻塞节点上下线，不再使用时需要调用 Close。
+func NewAgentHistory(repo repository.AgentConnectHistory, log *slog.Logger) HistoryHooker {
+	return newConnectHistory(&connectHistory{
+		kind: "agent",
+		log:  log,
+		insert: func(ctx context.Context, id bson.ObjectID, p Peer, ts model.TunnelStatHistory) error {
+			inf := p.Info()
+			dat := &model.AgentConnectHistory{
+				ID:         id,
+				AgentID:    p.ID(),
+				MachineID:  inf.Name,
+				Semver:     inf.Semver,
+				Inet:       inf.Inet,
+				Goos:       inf.Goos,
+				Goarch:     inf.Goarch,
+				TunnelStat: ts,
+			}
+			_, err := repo.InsertOne(ctx, dat)
+			return err
+		},
+		update: func(ctx context.Context, id bson.ObjectID, ts model.TunnelStatHistory) error {
+			_, err := repo.UpdateByID(ctx, id, bson.M{"$set": bson.M{"tunnel_stat": ts}})
+			return err
+		},
+		migrate: func(ctx context.Context, id bson.ObjectID, mig *model.TunnelMigration) error {
+			_, err := repo.UpdateByID(ctx, id, migrateUpdate(mig))
+			return err
+		},
+	})
+}
+
+// NewBrokerHistory 将 broker 上下线记录写入 broker_connect_history 的回调。
+//
+// 上线时插入记录，下线时补充下线时间、在线时长和流量。
+// 数据库写入由后台协程异步完成，不阻塞节点上下线，不再使用时需要调用 Close。
+func NewBrokerHistory(repo repository.BrokerConnectHistory, log *slog.Logger) HistoryHooker {
+	return newConnectHistory(&connectHistory{
+		kind: "broker",
+		log:  log,
+		insert: func(ctx context.Context, id bson.ObjectID, p Peer, ts model.TunnelStatHistory) error {
+			inf := p.Info()
+			dat := &model.BrokerConnectHistory{
+				ID:         id,
+				Broker:     p.ID(),
+				Name:       inf.Name,
+				Semver:     inf.Semver,
+				Inet:       inf.Inet,
+				Goos:       inf.Goos,
+				Goarch:     inf.Goarch,
+				TunnelStat: ts,
+			}
+			_, err := repo.InsertOne(ctx, dat)
+			return err
+		},
+		update: func(ctx context.Context, id bson.ObjectID, ts model.TunnelStatHistory) error {
+			_, err := repo.UpdateByID(ctx, id, bson.M{"$set": bson.M{"tunnel_stat": ts}})
+			return err
+		},
+		migrate: func(ctx context.Context, id bson.ObjectID, mig *model.TunnelMigration) error {
+			_, err := repo.UpdateByID(ctx, id, migrateUpdate(mig))
+			return err
+		},
+	})
+}
+
+// historyWorkers 写入数据库的后台协程数，historyQueue 为每个协程的队列长度，
+// historyWait 为队列已满时下线记录最多等待的时间。
+const (
+	historyWorkers = 4
+	historyQueue   = 1024
+	historyWait    = 5 * time.Second
+)
+
+func newConnectHistory(ch *connectHistory) *connectHistory {
+	size := ch.queueSize
+	if size <= 0 {
+		size = historyQueue
+	}
+	ch.ctx, ch.cancel = context.WithCancel(context.Background())
+	ch.dropped = metrics.GetOrCreateCounter(`linkhub_history_dropped_total{kind="` + ch.kind + `"}`)
+	ch.queues = make([]chan func(context.Context), historyWorkers)
+	ch.wg.Add(len(ch.queues))
+	for i := range ch.queues {
+		queue := make(chan func(context.Context), size)
+		ch.queues[i] = queue
+		go ch.work(queue)
+	}
+
+	return ch
+}
+
+type connectHistory struct {
+	kind      string // 节点类型，作为指标的标签
+	log       *slog.Logger
+	insert    func(context.Context, bson.ObjectID, Peer, model.TunnelStatHistory) error
+	update    func(context.Context, bson.ObjectID, model.TunnelStatHistory) error
+	migrate   func(context.Context, bson.ObjectID, *model.TunnelMigration) error
+	queueSize int // 每个队列的长度，小于等于 0 时为 historyQueue
+
+	records sync.Map                     // Peer -> *historyRecord
+	queues  []chan func(context.Context) // 按记录 ID 分配队列，保证同一条记录的写入有序
+	mutex   sync.RWMutex                 // 投递时持有读锁，关闭队列时持有写锁
+	closed  bool
+	ctx     context.Context // Close 超时后取消，中止剩余的写入
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	dropped *metrics.Counter
+}
+
+// historyRecord 在线节点的上线记录。
+type historyRecord struct {
+	id         bson.ObjectID
+	mutex      sync.Mutex
+	migrations []*model.TunnelMigration
+}
+
+func (r *historyRecord) addMigration(mig *model.TunnelMigration) {
+	r.mutex.Lock()
+	r.migrations = append(r.migrations, mig)
+	r.mutex.Unlock()
+}
+
+func (r *historyRecord) loadMigrations() []*model.TunnelMigration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return slices.Clone(r.migrations)
+}
+
+// migrateUpdate 连接迁移时更新上线记录的远端地址并追加迁移记录。
+func migrateUpdate(mig *model.TunnelMigration) bson.M {
+	return bson.M{
+		"$set":  bson.M{"tunnel_stat.remote_addr": mig.To},
+		"$push": bson.M{"tunnel_stat.migrations": mig},
+	}
+}
+
+// OnConnected 先登记上线记录再异步插入，记录 ID 在本地生成，
+// 随后的迁移和下线写入不必等待插入完成。
+func (ch *connectHistory) OnConnected(p Peer, connectAt time.Time) {
+	rec := &historyRecord{id: bson.NewObjectID()}
+	ch.records.Store(p, rec)
+
+	ts := ch.tunnelStat(p, connectAt, time.Time{})
+	ch.enqueue(rec.id, false, func(ctx context.Context) {
+		if err := ch.insert(ctx, rec.id, p, ts); err != nil {
+			ch.log.Warn("写入节点上线记录出错", "id", p.ID(), "error", err)
+		}
+	})
+}
+
+func (ch *connectHistory) OnDisconnected(p Peer, connectAt, disconnectAt time.Time) {
+	val, ok := ch.records.LoadAndDelete(p)
+	if !ok {
+		return
+	}
+	rec := val.(*historyRecord)
+
+	ts := ch.tunnelStat(p, connectAt, disconnectAt)
+	ts.Migrations = rec.loadMigrations()
+	// 丢弃下线记录会使上线记录永远停留在在线状态，所以队列已满时等待一段时间。
+	ch.enqueue(rec.id, true, func(ctx context.Context) {
+		if err := ch.update(ctx, rec.id, ts); err != nil {
+			ch.log.Warn("写入节点下线记录出错", "id", p.ID(), "error", err)
+		}
+	})
+}
+
+// OnMigrated 连接迁移不算作下线，只在上线记录中更新远端地址并追加迁移记录。
+func (ch *connectHistory) OnMigrated(p Peer, from, to string, at time.Time) {
+	val, ok := ch.records.Load(p)
+	if !ok {
+		return
+	}
+	rec := val.(*historyRecord)
+	mig := &model.TunnelMigration{From: from, To: to, At: at}
+	rec.addMigration(mig)
+
+	ch.enqueue(rec.id, false, func(ctx context.Context) {
+		if err := ch.migrate(ctx, rec.id, mig); err != nil {
+			ch.log.Warn("写入节点连接迁移记录出错", "id", p.ID(), "from", from, "to", to, "error", err)
+		}
+	})
+}
+
+// Close 关闭所有队列，等待后台协程写完队列中剩余的任务。
+func (ch *connectHistory) Close(ctx context.Context) error {
+	ch.mutex.Lock()
+	if !ch.closed {
+		ch.closed = true
+		for _, queue := range ch.queues {
+			close(queue)
+		}
+	}
+	ch.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ch.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		ch.cancel()
+		return nil
+	case <-ctx.Done():
+		ch.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// enqueue 将写入任务投递到记录所属的队列。队列已满时 wait 为 true 的任务最多等待 historyWait，
+// 其它任务直接丢弃，不阻塞调用方。丢弃的任务计入 linkhub_history_dropped_total。
+func (ch *connectHistory) enqueue(id bson.ObjectID, wait bool, task func(context.Context)) {
+	ch.mutex.RLock()
+	defer ch.mutex.RUnlock()
+	if ch.closed {
+		ch.drop(id, "已关闭")
+		return
+	}
+
+	queue := ch.queues[int(id[len(id)-1])%len(ch.queues)]
+	select {
+	case queue <- task:
+		return
+	default:
+	}
+	if wait {
+		timer := time.NewTimer(historyWait)
+		defer timer.Stop()
+		select {
+		case queue <- task:
+			return
+		case <-timer.C:
+		}
+	}
+	ch.drop(id, "队列已满")
+}
+
+func (ch *connectHistory) drop(id bson.ObjectID, reason string) {
+	ch.dropped.Inc()
+	ch.log.Warn("丢弃节点上下线记录的写入", "record_id", id, "reason", reason)
+}
+
+func (ch *connectHistory) work(queue <-chan func(context.Context)) {
+	defer ch.wg.Done()
+	for task := range queue {
+		ctx, cancel := context.WithTimeout(ch.ctx, 10*time.Second)
+		task(ctx)
+		cancel()
+	}
+}
+
+func (*connectHistory) tunnelStat(p Peer, connectAt, disconnectAt time.Time) model.TunnelStatHistory {
+	st := p.Stat().TunnelStat()
+	ts := model.TunnelStatHistory{
+		ConnectedAt:    connectAt,
+		DisconnectedAt: disconnectAt,
+		Library:        st.Library,
+		LocalAddr:      st.LocalAddr,
+		RemoteAddr:     st.RemoteAddr,
+		ReceiveBytes:   st.ReceiveBytes,
+		TransmitBytes:  st.TransmitBytes,
+	}
+	if !disconnectAt.IsZero() {
+		ts.Second = int64(disconnectAt.Sub(connectAt).Seconds())
+	}
+
+	return ts
+}
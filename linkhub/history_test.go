@@ -0,0 +1,211 @@
+package linkhub
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestHistoryAsync(t *testing.T) {
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	var calls []string
+	var ids []bson.ObjectID
+	record := func(op string, id bson.ObjectID) {
+		mutex.Lock()
+		calls = append(calls, op)
+		ids = append(ids, id)
+		mutex.Unlock()
+	}
+	done := make(chan struct{})
+	ch := newConnectHistory(&connectHistory{
+		log: slog.New(slog.DiscardHandler),
+		insert: func(_ context.Context, id bson.ObjectID, _ Peer, _ model.TunnelStatHistory) error {
+			<-release // 模拟缓慢的数据库
+			record("insert", id)
+			return nil
+		},
+		migrate: func(_ context.Context, id bson.ObjectID, _ *model.TunnelMigration) error {
+			record("migrate", id)
+			return nil
+		},
+		update: func(_ context.Context, id bson.ObjectID, ts model.TunnelStatHistory) error {
+			record("update", id)
+			if len(ts.Migrations) != 1 {
+				t.Errorf("update migrations = %d, want 1", len(ts.Migrations))
+			}
+			close(done)
+			return nil
+		},
+	})
+
+	//goland:noinspection GoUnhandledErrorResult
+	defer ch.Close(context.Background())
+
+	hub := NewHub("example.com")
+	p, _ := hub.Put(bson.NewObjectID(), newStubMuxer(1), stubInfo(1), time.Now())
+
+	// 插入未完成时上线回调也必须立即返回，并且记录已经登记，迁移不会丢失。
+	start := time.Now()
+	ch.OnConnected(p, p.ConnectedAt())
+	ch.OnMigrated(p, "10.0.0.1:443", "10.0.0.2:443", time.Now())
+	ch.OnDisconnected(p, p.ConnectedAt(), time.Now())
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("hooks blocked for %s", d)
+	}
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("history writes not finished")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	want := []string{"insert", "migrate", "update"}
+	for i, op := range want {
+		if calls[i] != op || ids[i] != ids[0] {
+			t.Fatalf("calls = %v ids = %v, want %v on one record", calls, ids, want)
+		}
+	}
+}
+
+// TestHistoryClose Close 等待已投递的写入完成后后台协程退出，之后的写入被丢弃。
+func TestHistoryClose(t *testing.T) {
+	var inserts atomic.Int64
+	ch := newConnectHistory(&connectHistory{
+		log: slog.New(slog.DiscardHandler),
+		insert: func(context.Context, bson.ObjectID, Peer, model.TunnelStatHistory) error {
+			time.Sleep(time.Millisecond)
+			inserts.Add(1)
+			return nil
+		},
+	})
+
+	hub := NewHub("example.com")
+	for i := range 100 {
+		p, _ := hub.Put(bson.NewObjectID(), newStubMuxer(i), stubInfo(i), time.Now())
+		ch.OnConnected(p, p.ConnectedAt())
+	}
+	if err := ch.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := inserts.Load(); n != 100 {
+		t.Fatalf("inserts = %d after Close, want 100", n)
+	}
+
+	dropped := ch.dropped.Get()
+	p, _ := hub.Put(bson.NewObjectID(), newStubMuxer(200), stubInfo(200), time.Now())
+	ch.OnConnected(p, p.ConnectedAt())
+	if ch.dropped.Get() != dropped+1 || inserts.Load() != 100 {
+		t.Fatal("write after Close was not dropped")
+	}
+	if err := ch.Close(context.Background()); err != nil {
+		t.Fatalf("second Close() = %v", err)
+	}
+}
+
+// TestHistoryCloseTimeout ctx 取消后中止剩余的写入。
+func TestHistoryCloseTimeout(t *testing.T) {
+	ch := newConnectHistory(&connectHistory{
+		log: slog.New(slog.DiscardHandler),
+		insert: func(ctx context.Context, _ bson.ObjectID, _ Peer, _ model.TunnelStatHistory) error {
+			<-ctx.Done() // 模拟卡住的数据库
+			return ctx.Err()
+		},
+	})
+
+	hub := NewHub("example.com")
+	p, _ := hub.Put(bson.NewObjectID(), newStubMuxer(1), stubInfo(1), time.Now())
+	ch.OnConnected(p, p.ConnectedAt())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := ch.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Close() = %v, want DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Close() took %s", d)
+	}
+}
+
+// TestHistoryBackpressure 队列已满时迁移等记录被丢弃并计数，下线记录等待队列腾出位置。
+func TestHistoryBackpressure(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	updated := make(chan bson.ObjectID, 1)
+	ch := newConnectHistory(&connectHistory{
+		log:       slog.New(slog.DiscardHandler),
+		queueSize: 1,
+		insert: func(context.Context, bson.ObjectID, Peer, model.TunnelStatHistory) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		},
+		migrate: func(context.Context, bson.ObjectID, *model.TunnelMigration) error {
+			return nil
+		},
+		update: func(_ context.Context, id bson.ObjectID, _ model.TunnelStatHistory) error {
+			updated <- id
+			return nil
+		},
+	})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	defer func() {
+		unblock()
+		_ = ch.Close(context.Background())
+	}()
+
+	hub := NewHub("example.com")
+	p, _ := hub.Put(bson.NewObjectID(), newStubMuxer(1), stubInfo(1), time.Now())
+	ch.OnConnected(p, p.ConnectedAt())
+	<-started // 后台协程卡在插入上
+
+	// 第一次迁移填满长度为 1 的队列，第二次被丢弃。
+	dropped := ch.dropped.Get()
+	ch.OnMigrated(p, "10.0.0.1:443", "10.0.0.2:443", time.Now())
+	ch.OnMigrated(p, "10.0.0.2:443", "10.0.0.3:443", time.Now())
+	if n := ch.dropped.Get() - dropped; n != 1 {
+		t.Fatalf("dropped = %d, want 1", n)
+	}
+	val, _ := ch.records.Load(p)
+	want := val.(*historyRecord).id
+
+	returned := make(chan struct{})
+	go func() {
+		ch.OnDisconnected(p, p.ConnectedAt(), time.Now())
+		close(returned)
+	}()
+	select {
+	case <-returned:
+		t.Fatal("disconnect write did not wait for the full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unblock()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("disconnect write still blocked after the queue drained")
+	}
+	select {
+	case id := <-updated:
+		if id != want {
+			t.Fatalf("updated record %s, want %s", id, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("disconnect write was lost")
+	}
+	if ch.dropped.Get()-dropped != 1 {
+		t.Fatal("disconnect write was counted as dropped")
+	}
+}
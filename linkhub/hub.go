@@ -1,16 +1,19 @@
 package linkhub
 
 import (
-	"sync"
+	"context"
+	"sync/atomic"
+	"time"
 
 	"github.com/xmx/aegis-common/muxlink/muxconn"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 type Huber interface {
-	// Put 将节点加入到连接池，如果 id 已存在则加入失败，返回 nil。
-	// 加入成功则返回 Peer 节点。
-	Put(id bson.ObjectID, mux muxconn.Muxer, inf Info) Peer
+	// Put 将节点加入到连接池，connectAt 为通道建立时间，零值则取当前时间。
+	// 如果 id 已存在则按照 TakeoverPolicy 处理，被替换的旧节点通道会被关闭。
+	// 加入成功则返回 Peer 节点，被拒绝时返回 nil。
+	Put(id bson.ObjectID, mux muxconn.Muxer, inf Info, connectAt time.Time) (Peer, PutResult)
 
 	Get(host string) Peer
 
@@ -20,51 +23,69 @@ type Huber interface {
 
 	DelID(id bson.ObjectID) Peer
 
+	// DelPeer 仅当连接池中的节点就是 p 时才将其删除。
+	// 旧节点被接管后，其连接处理协程退出时应该调用该方法，避免误删新节点。
+	DelPeer(p Peer) bool
+
+	// Migrate 检查节点的远端地址是否变化。QUIC 连接迁移后通道不变但远端地址会变化，
+	// 此时节点保持不变，只发布 EventMigrate 事件并回调 MigrateHooker，返回 true。
+	// 心跳成功后会自动检查。
+	Migrate(p Peer) bool
+
 	// Domain 域。
 	Domain() string
 
 	Peers() []Peer
-}
 
-func NewHub(domain string) Huber {
-	return &safeMapHub{
-		domain: domain,
-		peers:  make(map[string]Peer, 16),
-	}
+	// Find 按照节点信息查询节点，查询条件有误时返回错误。
+	Find(q Query) ([]Peer, error)
 
-}
+	// Drain 排空连接池：拒绝新节点加入，调用 cfg.Notify 通知现有节点迁移到其它 node，
+	// 等待节点离开直到超时，最后强制关闭剩余节点的通道。
+	// 排空后连接池不再接受新节点，直到调用 Resume。
+	Drain(ctx context.Context, cfg DrainConfig) *DrainReport
 
-type safeMapHub struct {
-	domain string
-	mutex  sync.RWMutex
-	peers  map[string]Peer
+	// Resume 结束排空状态，重新接受新节点加入。
+	Resume()
+
+	// Draining 是否处于排空状态。
+	Draining() bool
+
+	// Subscribe 订阅节点上下线事件，size 为缓冲区大小，缓冲区满时按照 drop 策略丢弃事件。
+	// 订阅者不再使用时需要调用 Subscription.Close 取消订阅。
+	Subscribe(size int, drop DropPolicy) Subscription
 }
 
-func (s *safeMapHub) Put(id bson.ObjectID, mux muxconn.Muxer, inf Info) Peer {
-	host := resolveHost(id, s.domain)
-	peer := &muxPeer{
-		id:   id,
-		mux:  mux,
-		inf:  inf,
-		host: host,
+func NewHub(domain string, opts ...Option) Huber {
+	opt := newOption(opts)
+	core := &hubCore{
+		domain:   domain,
+		takeover: opt.takeover,
+		events:   new(eventBus),
+		hooks:    opt.hooks,
+	}
+	if opt.shards > 1 {
+		return newShardHub(core, opt.shards)
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	if _, exists := s.peers[host]; exists {
-		return nil
+	return &safeMapHub{
+		hubCore: core,
+		shard:   newPeerShard(16),
 	}
-	s.peers[host] = peer
+}
 
-	return peer
+type safeMapHub struct {
+	*hubCore
+	shard *peerShard
 }
 
-func (s *safeMapHub) Get(host string) Peer {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+func (s *safeMapHub) Put(id bson.ObjectID, mux muxconn.Muxer, inf Info, connectAt time.Time) (Peer, PutResult) {
+	peer := newMuxPeer(id, mux, inf, s.domain, connectAt)
+	return s.shard.put(peer, s.hubCore)
+}
 
-	return s.peers[host]
+func (s *safeMapHub) Get(host string) Peer {
+	return s.shard.get(host)
 }
 
 func (s *safeMapHub) GetID(id bson.ObjectID) Peer {
@@ -73,15 +94,7 @@ func (s *safeMapHub) GetID(id bson.ObjectID) Peer {
 }
 
 func (s *safeMapHub) Del(host string) Peer {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
-	peer := s.peers[host]
-	if peer != nil {
-		delete(s.peers, host)
-	}
-
-	return peer
+	return s.shard.del(host, s.hubCore)
 }
 
 func (s *safeMapHub) DelID(id bson.ObjectID) Peer {
@@ -89,20 +102,85 @@ func (s *safeMapHub) DelID(id bson.ObjectID) Peer {
 	return s.Del(host)
 }
 
-func (s *safeMapHub) Domain() string {
-	return s.domain
+func (s *safeMapHub) DelPeer(p Peer) bool {
+	if p == nil {
+		return false
+	}
+
+	return s.shard.delPeer(p, s.hubCore)
+}
+
+func (s *safeMapHub) Migrate(p Peer) bool {
+	if p == nil {
+		return false
+	}
+
+	return s.shard.migrate(p, s.hubCore)
 }
 
 func (s *safeMapHub) Peers() []Peer {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	return s.shard.appendTo(nil)
+}
+
+func (s *safeMapHub) Find(q Query) ([]Peer, error) {
+	cq, err := q.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.shard.index.find(cq, nil), nil
+}
+
+func (s *safeMapHub) Drain(ctx context.Context, cfg DrainConfig) *DrainReport {
+	return s.drain(ctx, s, cfg)
+}
+
+// hubCore 各 Huber 实现共用的配置与事件。
+type hubCore struct {
+	domain   string
+	takeover TakeoverPolicy
+	events   *eventBus
+	draining atomic.Bool
+	hooks    []ServerHooker
+}
+
+func (c *hubCore) Domain() string {
+	return c.domain
+}
+
+// connected 节点加入后回调，不能在持有锁时调用。
+func (c *hubCore) connected(p Peer) {
+	for _, h := range c.hooks {
+		h.OnConnected(p, p.ConnectedAt())
+	}
+}
+
+// disconnected 节点离开后回调，不能在持有锁时调用。
+func (c *hubCore) disconnected(p Peer, disconnectAt time.Time) {
+	for _, h := range c.hooks {
+		h.OnDisconnected(p, p.ConnectedAt(), disconnectAt)
+	}
+}
 
-	res := make([]Peer, 0, len(s.peers))
-	for _, peer := range s.peers {
-		res = append(res, peer)
+// migrated 节点连接迁移后回调，不能在持有锁时调用。
+func (c *hubCore) migrated(p Peer, from, to string, at time.Time) {
+	for _, h := range c.hooks {
+		if mh, ok := h.(MigrateHooker); ok {
+			mh.OnMigrated(p, from, to, at)
+		}
 	}
+}
+
+func (c *hubCore) Resume() {
+	c.draining.Store(false)
+}
+
+func (c *hubCore) Draining() bool {
+	return c.draining.Load()
+}
 
-	return res
+func (c *hubCore) Subscribe(size int, drop DropPolicy) Subscription {
+	return c.events.subscribe(size, drop)
 }
 
 func resolveHost(id bson.ObjectID, domain string) string {
@@ -0,0 +1,109 @@
+package linkhub
+
+import (
+	"context"
+	"hash/maphash"
+	"time"
+
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func newShardHub(core *hubCore, n int) *shardHub {
+	shards := make([]*peerShard, n)
+	for i := range shards {
+		shards[i] = newPeerShard(16)
+	}
+
+	return &shardHub{
+		hubCore: core,
+		seed:    maphash.MakeSeed(),
+		shards:  shards,
+	}
+}
+
+// shardHub 按照 host 哈希分片的连接池，适用于大量节点同时上下线的场景。
+// 每个分片有独立的锁和索引，事件发布也不经过全局锁，各分片之间互不影响锁竞争。
+type shardHub struct {
+	*hubCore
+	seed   maphash.Seed
+	shards []*peerShard
+}
+
+func (s *shardHub) Put(id bson.ObjectID, mux muxconn.Muxer, inf Info, connectAt time.Time) (Peer, PutResult) {
+	peer := newMuxPeer(id, mux, inf, s.domain, connectAt)
+	return s.shard(peer.host).put(peer, s.hubCore)
+}
+
+func (s *shardHub) Get(host string) Peer {
+	return s.shard(host).get(host)
+}
+
+func (s *shardHub) GetID(id bson.ObjectID) Peer {
+	host := resolveHost(id, s.domain)
+	return s.Get(host)
+}
+
+func (s *shardHub) Del(host string) Peer {
+	return s.shard(host).del(host, s.hubCore)
+}
+
+func (s *shardHub) DelID(id bson.ObjectID) Peer {
+	host := resolveHost(id, s.domain)
+	return s.Del(host)
+}
+
+func (s *shardHub) DelPeer(p Peer) bool {
+	if p == nil {
+		return false
+	}
+
+	return s.shard(p.Host()).delPeer(p, s.hubCore)
+}
+
+func (s *shardHub) Migrate(p Peer) bool {
+	if p == nil {
+		return false
+	}
+
+	return s.shard(p.Host()).migrate(p, s.hubCore)
+}
+
+// Peers 逐个分片复制节点，返回的结果不是某一时刻的全局快照。
+func (s *shardHub) Peers() []Peer {
+	var size int
+	for _, sd := range s.shards {
+		size += sd.len()
+	}
+
+	res := make([]Peer, 0, size)
+	for _, sd := range s.shards {
+		res = sd.appendTo(res)
+	}
+
+	return res
+}
+
+// Find 逐个分片查询，各分片的索引互相独立。
+func (s *shardHub) Find(q Query) ([]Peer, error) {
+	cq, err := q.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	var rets []Peer
+	for _, sd := range s.shards {
+		rets = sd.index.find(cq, rets)
+	}
+
+	return rets, nil
+}
+
+func (s *shardHub) Drain(ctx context.Context, cfg DrainConfig) *DrainReport {
+	return s.drain(ctx, s, cfg)
+}
+
+func (s *shardHub) shard(host string) *peerShard {
+	sum := maphash.String(s.seed, host)
+	return s.shards[sum%uint64(len(s.shards))]
+}
@@ -0,0 +1,334 @@
+package linkhub
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// stubMuxer 只实现连接池用到的方法。
+type stubMuxer struct {
+	muxconn.Muxer
+	remote net.Addr
+}
+
+func (m stubMuxer) Addr() net.Addr             { return nil }
+func (m stubMuxer) RemoteAddr() net.Addr       { return m.remote }
+func (m stubMuxer) Close() error               { return nil }
+func (m stubMuxer) Library() (string, string)  { return "stub", "" }
+func (m stubMuxer) Traffic() (uint64, uint64)  { return 0, 0 }
+func (m stubMuxer) NumStreams() (int64, int64) { return 0, 0 }
+
+func newStubMuxer(i int) stubMuxer {
+	return stubMuxer{remote: &net.UDPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 443}}
+}
+
+// pipeMuxers 通过内存管道建立一对真实的 smux 通道，返回服务端和客户端，测试结束后关闭。
+func pipeMuxers(t testing.TB) (muxconn.Muxer, muxconn.Muxer) {
+	t.Helper()
+	a, b := net.Pipe()
+	srv, err := muxconn.NewSMUX(context.Background(), a, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := muxconn.NewSMUX(context.Background(), b, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = srv.Close()
+		_ = cli.Close()
+	})
+
+	return srv, cli
+}
+
+// serveEcho 将 mux 上对端打开的每个子流收到的数据原样写回，直到通道关闭。
+func serveEcho(mux muxconn.Muxer) {
+	for {
+		conn, err := mux.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			//goland:noinspection GoUnhandledErrorResult
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+	}
+}
+
+func stubInfo(i int) Info {
+	return Info{
+		Name:     "agent-" + strconv.Itoa(i),
+		Inet:     "10.0." + strconv.Itoa(i>>8&0xff) + "." + strconv.Itoa(i&0xff),
+		Goos:     "linux",
+		Goarch:   "amd64",
+		Hostname: "host-" + strconv.Itoa(i),
+		Semver:   "1." + strconv.Itoa(i%8) + ".0",
+	}
+}
+
+// hubCases 对比单个 map 和分片两种实现。
+var hubCases = []struct {
+	name string
+	opts []Option
+}{
+	{name: "map"},
+	{name: "shard64", opts: []Option{WithShards(64)}},
+}
+
+// newBenchHub 创建连接池并挂上一个不消费的订阅者，覆盖事件发布的开销。
+func newBenchHub(b *testing.B, opts []Option) Huber {
+	hub := NewHub("example.com", opts...)
+	sub := hub.Subscribe(1024, DropNewest)
+	b.Cleanup(sub.Close)
+
+	return hub
+}
+
+func BenchmarkPut(b *testing.B) {
+	for _, bc := range hubCases {
+		b.Run(bc.name, func(b *testing.B) {
+			hub := newBenchHub(b, bc.opts)
+			var seq atomic.Int64
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := int(seq.Add(1))
+					hub.Put(bson.NewObjectID(), newStubMuxer(i), stubInfo(i), time.Time{})
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkDel(b *testing.B) {
+	for _, bc := range hubCases {
+		b.Run(bc.name, func(b *testing.B) {
+			hub := newBenchHub(b, bc.opts)
+			ids := make([]bson.ObjectID, b.N)
+			for i := range ids {
+				ids[i] = bson.NewObjectID()
+				hub.Put(ids[i], newStubMuxer(i), stubInfo(i), time.Time{})
+			}
+			var seq atomic.Int64
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := seq.Add(1) - 1
+					hub.DelID(ids[i])
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkMixed 大量节点在线时并发上下线，同时有少量读取和查询。
+func BenchmarkMixed(b *testing.B) {
+	const online = 50000
+	for _, bc := range hubCases {
+		b.Run(bc.name, func(b *testing.B) {
+			hub := newBenchHub(b, bc.opts)
+			ids := make([]bson.ObjectID, online)
+			for i := range ids {
+				ids[i] = bson.NewObjectID()
+				hub.Put(ids[i], newStubMuxer(i), stubInfo(i), time.Time{})
+			}
+			var seq atomic.Int64
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := int(seq.Add(1))
+					id := ids[i%online]
+					switch i % 16 {
+					case 0:
+						_, _ = hub.Find(Query{Name: "agent-" + strconv.Itoa(i%online), Goos: "linux"})
+					case 1, 2, 3:
+						hub.GetID(id)
+					default:
+						if hub.DelID(id) == nil {
+							hub.Put(id, newStubMuxer(i), stubInfo(i), time.Time{})
+						}
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestShardHubFind(t *testing.T) {
+	for _, bc := range hubCases {
+		t.Run(bc.name, func(t *testing.T) {
+			hub := NewHub("example.com", bc.opts...)
+			sub := hub.Subscribe(2048, DropNewest)
+			defer sub.Close()
+
+			for i := range 1000 {
+				hub.Put(bson.NewObjectID(), newStubMuxer(i), stubInfo(i), time.Time{})
+			}
+			peers, err := hub.Find(Query{MinSemver: "1.2.0", MaxSemver: "1.4.0"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(peers) != 250 {
+				t.Fatalf("Find() = %d peers, want 250", len(peers))
+			}
+			if n := len(sub.C()); n != 1000 || sub.Dropped() != 0 {
+				t.Fatalf("events = %d dropped = %d, want 1000 and 0", n, sub.Dropped())
+			}
+		})
+	}
+}
+
+// nextEvent 读取已经投递的下一个事件，发布是同步的，所以不需要等待。
+func nextEvent(t *testing.T, sub Subscription) Event {
+	t.Helper()
+	select {
+	case evt, ok := <-sub.C():
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return evt
+	default:
+		t.Fatal("no event delivered")
+	}
+
+	return Event{}
+}
+
+func TestSubscribe(t *testing.T) {
+	for _, bc := range hubCases {
+		t.Run(bc.name, func(t *testing.T) {
+			hub := NewHub("example.com", bc.opts...)
+			first := hub.Subscribe(8, DropNewest)
+			second := hub.Subscribe(8, DropNewest)
+
+			p, _ := hub.Put(bson.NewObjectID(), newStubMuxer(1), stubInfo(1), time.Time{})
+			for _, sub := range []Subscription{first, second} {
+				if evt := nextEvent(t, sub); evt.Type != EventJoin || evt.Peer != p || evt.At.IsZero() {
+					t.Fatalf("event = %v %v, want join", evt.Type, evt.Peer)
+				}
+			}
+
+			// 取消订阅后通道关闭，不再收到事件，其它订阅者不受影响。
+			first.Close()
+			first.Close()
+			hub.DelPeer(p)
+			if _, ok := <-first.C(); ok {
+				t.Fatal("event delivered after Close")
+			}
+			if evt := nextEvent(t, second); evt.Type != EventLeave || evt.Peer != p {
+				t.Fatalf("event = %v, want leave", evt.Type)
+			}
+			second.Close()
+		})
+	}
+}
+
+func TestSubscribeDrop(t *testing.T) {
+	cases := []struct {
+		drop  DropPolicy
+		first int // 缓冲区中保留的第一个节点序号
+	}{
+		{drop: DropNewest, first: 0},
+		{drop: DropOldest, first: 2},
+	}
+	for _, tc := range cases {
+		hub := NewHub("example.com")
+		slow := hub.Subscribe(3, tc.drop)
+		fast := hub.Subscribe(8, tc.drop)
+
+		peers := make([]Peer, 5)
+		for i := range peers {
+			peers[i], _ = hub.Put(bson.NewObjectID(), newStubMuxer(i), stubInfo(i), time.Time{})
+		}
+
+		// 慢订阅者缓冲区满后按照策略丢弃，不会阻塞发布，也不影响其它订阅者。
+		if n := slow.Dropped(); n != 2 {
+			t.Fatalf("policy %d dropped = %d, want 2", tc.drop, n)
+		}
+		for i := range 3 {
+			if evt := nextEvent(t, slow); evt.Peer != peers[tc.first+i] {
+				t.Fatalf("policy %d event %d is for the wrong peer", tc.drop, i)
+			}
+		}
+		if fast.Dropped() != 0 || len(fast.C()) != len(peers) {
+			t.Fatalf("policy %d fast subscriber got %d events", tc.drop, len(fast.C()))
+		}
+		slow.Close()
+		fast.Close()
+	}
+}
+
+// TestSubscribeTakeover 同 ID 节点接管时依次收到 join、replace、leave，
+// 旧节点的连接协程退出时不会产生多余的 leave 事件，被拒绝的接管不产生事件。
+func TestSubscribeTakeover(t *testing.T) {
+	for _, bc := range hubCases {
+		t.Run(bc.name, func(t *testing.T) {
+			opts := append([]Option{WithTakeover(TakeoverReplace)}, bc.opts...)
+			hub := NewHub("example.com", opts...)
+			sub := hub.Subscribe(8, DropNewest)
+			defer sub.Close()
+
+			id := bson.NewObjectID()
+			old, res := hub.Put(id, newStubMuxer(1), stubInfo(1), time.Time{})
+			if res != PutJoined {
+				t.Fatalf("first Put = %v", res)
+			}
+			cur, res := hub.Put(id, newStubMuxer(2), stubInfo(2), time.Time{})
+			if res != PutReplaced {
+				t.Fatalf("second Put = %v", res)
+			}
+			if hub.DelPeer(old) {
+				t.Fatal("DelPeer removed the new peer")
+			}
+			if !hub.DelPeer(cur) {
+				t.Fatal("DelPeer did not remove the current peer")
+			}
+
+			want := []struct {
+				typ  EventType
+				peer Peer
+				old  Peer
+			}{
+				{EventJoin, old, nil},
+				{EventReplace, cur, old},
+				{EventLeave, cur, nil},
+			}
+			for i, w := range want {
+				evt := nextEvent(t, sub)
+				if evt.Type != w.typ || evt.Peer != w.peer || evt.Old != w.old {
+					t.Fatalf("event %d = %v, want %v", i, evt.Type, w.typ)
+				}
+			}
+			if n := len(sub.C()); n != 0 {
+				t.Fatalf("%d unexpected events", n)
+			}
+
+			reject := NewHub("example.com", bc.opts...)
+			rsub := reject.Subscribe(8, DropNewest)
+			defer rsub.Close()
+			reject.Put(id, newStubMuxer(1), stubInfo(1), time.Time{})
+			if _, res := reject.Put(id, newStubMuxer(2), stubInfo(2), time.Time{}); res != PutRejected {
+				t.Fatalf("Put = %v, want rejected", res)
+			}
+			if n := len(rsub.C()); n != 1 {
+				t.Fatalf("rejected takeover published %d events, want only the join", n)
+			}
+		})
+	}
+}
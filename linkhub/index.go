@@ -0,0 +1,236 @@
+package linkhub
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+)
+
+func newPeerIndex() *peerIndex {
+	return &peerIndex{
+		goos:     make(map[string]map[string]Peer, 8),
+		goarch:   make(map[string]map[string]Peer, 8),
+		name:     make(map[string]map[string]Peer, 64),
+		hostname: make(map[string]map[string]Peer, 64),
+		semver:   newRangeIndex(cmp.Compare[uint64]),
+		inet:     newRangeIndex(netip.Addr.Compare),
+	}
+}
+
+// peerIndex 一个分片内节点信息的二级索引，在 Put/Del 时维护，查询时避免扫描全部节点。
+//
+// 精确匹配的字段使用 map 索引，版本号和 IP 使用 rangeIndex 支持范围查询。
+// hostname 索引包含分片内的全部节点，无法使用其它索引时用它遍历。
+type peerIndex struct {
+	mutex    sync.RWMutex
+	goos     map[string]map[string]Peer
+	goarch   map[string]map[string]Peer
+	name     map[string]map[string]Peer
+	hostname map[string]map[string]Peer
+	semver   *rangeIndex[uint64]
+	inet     *rangeIndex[netip.Addr]
+}
+
+func (pi *peerIndex) add(p Peer) {
+	inf, host := p.Info(), p.Host()
+
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+
+	pi.addExact(pi.goos, inf.Goos, host, p)
+	pi.addExact(pi.goarch, inf.Goarch, host, p)
+	pi.addExact(pi.name, inf.Name, host, p)
+	pi.addExact(pi.hostname, inf.Hostname, host, p)
+	// 版本号无效的节点不进入版本索引，与 compiledQuery.match 一致，不匹配设置了版本范围的查询。
+	if num, err := semverNumber(inf.Semver); err == nil {
+		pi.semver.add(num, host, p)
+	}
+	if addr, err := netip.ParseAddr(inf.Inet); err == nil {
+		pi.inet.add(addr.Unmap(), host, p)
+	}
+}
+
+func (pi *peerIndex) remove(p Peer) {
+	inf, host := p.Info(), p.Host()
+
+	pi.mutex.Lock()
+	defer pi.mutex.Unlock()
+
+	pi.removeExact(pi.goos, inf.Goos, host, p)
+	pi.removeExact(pi.goarch, inf.Goarch, host, p)
+	pi.removeExact(pi.name, inf.Name, host, p)
+	pi.removeExact(pi.hostname, inf.Hostname, host, p)
+	if num, err := semverNumber(inf.Semver); err == nil {
+		pi.semver.remove(num, host, p)
+	}
+	if addr, err := netip.ParseAddr(inf.Inet); err == nil {
+		pi.inet.remove(addr.Unmap(), host, p)
+	}
+}
+
+// find 将满足条件的节点追加到 rets。
+func (pi *peerIndex) find(cq *compiledQuery, rets []Peer) []Peer {
+	pi.mutex.RLock()
+	defer pi.mutex.RUnlock()
+
+	// 从各个可用索引中挑选候选集最小的一个，再逐个校验全部条件。
+	var bucket map[string]Peer
+	var hasBucket bool
+	pick := func(m map[string]map[string]Peer, key string) {
+		b := m[key]
+		if !hasBucket || len(b) < len(bucket) {
+			bucket, hasBucket = b, true
+		}
+	}
+	if cq.Goos != "" {
+		pick(pi.goos, cq.Goos)
+	}
+	if cq.Goarch != "" {
+		pick(pi.goarch, cq.Goarch)
+	}
+	if cq.Name != "" {
+		pick(pi.name, cq.Name)
+	}
+	if cq.Hostname != "" && !cq.hostnameGlob {
+		pick(pi.hostname, cq.Hostname)
+	}
+
+	var ranged []Peer
+	var hasRanged bool
+	if cq.prefix.IsValid() {
+		prefix := cq.prefix
+		ranged, hasRanged = pi.inet.scan(prefix.Addr(), prefix.Contains), true
+	}
+	if cq.hasMin || cq.hasMax {
+		maxSemver := cq.maxSemver
+		below := func(num uint64) bool { return !cq.hasMax || num < maxSemver }
+		if sr := pi.semver.scan(cq.minSemver, below); !hasRanged || len(sr) < len(ranged) {
+			ranged, hasRanged = sr, true
+		}
+	}
+
+	if hasRanged && (!hasBucket || len(ranged) < len(bucket)) {
+		for _, p := range ranged {
+			if cq.match(p.Info()) {
+				rets = append(rets, p)
+			}
+		}
+		return rets
+	}
+	if hasBucket {
+		for _, p := range bucket {
+			if cq.match(p.Info()) {
+				rets = append(rets, p)
+			}
+		}
+		return rets
+	}
+
+	for _, b := range pi.hostname {
+		for _, p := range b {
+			if cq.match(p.Info()) {
+				rets = append(rets, p)
+			}
+		}
+	}
+
+	return rets
+}
+
+func (*peerIndex) addExact(m map[string]map[string]Peer, key, host string, p Peer) {
+	b := m[key]
+	if b == nil {
+		b = make(map[string]Peer, 8)
+		m[key] = b
+	}
+	b[host] = p
+}
+
+func (*peerIndex) removeExact(m map[string]map[string]Peer, key, host string, p Peer) {
+	b := m[key]
+	if b[host] != p {
+		return
+	}
+	delete(b, host)
+	if len(b) == 0 {
+		delete(m, key)
+	}
+}
+
+type rangeEntry[K any] struct {
+	key  K
+	host string
+	peer Peer
+}
+
+func newRangeIndex[K any](cmp func(a, b K) int) *rangeIndex[K] {
+	return &rangeIndex[K]{
+		cmp:  cmp,
+		ents: make(map[string]rangeEntry[K], 64),
+	}
+}
+
+// rangeIndex 支持范围查询的索引。
+//
+// Put/Del 只修改 map，是 O(1) 的，避免连接风暴时每次插入都移动有序切片；
+// 有序切片在变更后的第一次查询时重建，查询远少于上下线，重建的开销可以接受。
+//
+// 写操作在 peerIndex 的写锁内进行，查询在读锁内进行，mutex 只用于串行化并发查询时的重建。
+type rangeIndex[K any] struct {
+	cmp    func(a, b K) int
+	ents   map[string]rangeEntry[K] // host -> 条目
+	mutex  sync.Mutex
+	dirty  bool
+	sorted []rangeEntry[K] // 按照 key、host 排序
+}
+
+func (ri *rangeIndex[K]) add(key K, host string, p Peer) {
+	ri.ents[host] = rangeEntry[K]{key: key, host: host, peer: p}
+	ri.dirty = true
+}
+
+func (ri *rangeIndex[K]) remove(_ K, host string, p Peer) {
+	if ent, ok := ri.ents[host]; ok && ent.peer == p {
+		delete(ri.ents, host)
+		ri.dirty = true
+	}
+}
+
+// scan 从 from（包含）开始顺序遍历，直到 while 返回 false。
+func (ri *rangeIndex[K]) scan(from K, while func(K) bool) []Peer {
+	ri.mutex.Lock()
+	defer ri.mutex.Unlock()
+
+	if ri.dirty {
+		ri.sorted = ri.sorted[:0]
+		for _, ent := range ri.ents {
+			ri.sorted = append(ri.sorted, ent)
+		}
+		slices.SortFunc(ri.sorted, func(a, b rangeEntry[K]) int {
+			if n := ri.cmp(a.key, b.key); n != 0 {
+				return n
+			}
+			return strings.Compare(a.host, b.host)
+		})
+		ri.dirty = false
+	}
+
+	idx, _ := slices.BinarySearchFunc(ri.sorted, from, func(ent rangeEntry[K], k K) int {
+		if n := ri.cmp(ent.key, k); n != 0 {
+			return n
+		}
+		return 1 // 相同 key 的条目都排在 from 之后
+	})
+
+	var rets []Peer
+	for _, ent := range ri.sorted[idx:] {
+		if !while(ent.key) {
+			break
+		}
+		rets = append(rets, ent.peer)
+	}
+
+	return rets
+}
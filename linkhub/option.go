@@ -0,0 +1,46 @@
+package linkhub
+
+type Option func(*option)
+
+// WithTakeover 设置同 ID 节点重连时的接管策略，默认 TakeoverReject。
+func WithTakeover(p TakeoverPolicy) Option {
+	return func(o *option) {
+		o.takeover = p
+	}
+}
+
+// WithShards 将连接池按照 host 哈希拆分为 n 个分片，n <= 1 时使用单个 map。
+// 节点数量较多（上万）且上下线频繁时，分片可以降低锁竞争。
+func WithShards(n int) Option {
+	return func(o *option) {
+		o.shards = n
+	}
+}
+
+// WithHooker 注册节点上下线回调，回调在 Put/Del 的调用协程中按照注册顺序同步执行。
+func WithHooker(hooks ...ServerHooker) Option {
+	return func(o *option) {
+		for _, h := range hooks {
+			if h != nil {
+				o.hooks = append(o.hooks, h)
+			}
+		}
+	}
+}
+
+type option struct {
+	takeover TakeoverPolicy
+	shards   int
+	hooks    []ServerHooker
+}
+
+func newOption(opts []Option) option {
+	var opt option
+	for _, fn := range opts {
+		if fn != nil {
+			fn(&opt)
+		}
+	}
+
+	return opt
+}
@@ -2,6 +2,7 @@ package linkhub
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/xmx/aegis-common/muxlink/muxconn"
@@ -12,10 +13,19 @@ type ConfigLoader[T any] interface {
 	LoadConfig(ctx context.Context) (*T, error)
 }
 
+// ServerHooker 节点上下线回调，通过 WithHooker 注册到连接池。
 type ServerHooker interface {
-	OnConnected(inf Info, connectAt time.Time)
+	// OnConnected 节点加入连接池后调用。
+	OnConnected(p Peer, connectAt time.Time)
 
-	OnDisconnected(inf Info, connectAt, disconnectAt time.Time)
+	// OnDisconnected 节点离开连接池（包括被新连接替换）后调用。
+	OnDisconnected(p Peer, connectAt, disconnectAt time.Time)
+}
+
+// MigrateHooker 节点连接迁移回调，通过 WithHooker 注册的 ServerHooker 可以选择实现该接口。
+type MigrateHooker interface {
+	// OnMigrated 节点的远端地址变化（例如 QUIC 连接迁移）后调用，节点本身保持不变。
+	OnMigrated(p Peer, from, to string, at time.Time)
 }
 
 type Peer interface {
@@ -30,19 +40,96 @@ type Peer interface {
 
 	// Info 节点信息。
 	Info() Info
+
+	// ConnectedAt 通道建立时间。
+	ConnectedAt() time.Time
+
+	// Stat 通道统计快照。
+	Stat() Stat
+
+	// Keepalive 记录一次心跳，rtt 为本次心跳测得的往返时延，未测量时传 0。
+	Keepalive(at time.Time, rtt time.Duration)
+}
+
+func newMuxPeer(id bson.ObjectID, mux muxconn.Muxer, inf Info, domain string, connectAt time.Time) *muxPeer {
+	if connectAt.IsZero() {
+		connectAt = time.Now()
+	}
+
+	return &muxPeer{
+		id:        id,
+		mux:       mux,
+		inf:       inf,
+		host:      resolveHost(id, domain),
+		remote:    addrString(mux.RemoteAddr()),
+		connectAt: connectAt,
+	}
 }
 
 type muxPeer struct {
-	id   bson.ObjectID
-	mux  muxconn.Muxer
-	inf  Info
-	host string
+	id        bson.ObjectID
+	mux       muxconn.Muxer
+	inf       Info
+	host      string
+	remote    string // 最近一次记录的远端地址，受所在分片的锁保护
+	connectAt time.Time
+	aliveAt   atomic.Int64 // 最近一次心跳时间 UnixNano
+	srtt      atomic.Int64 // 心跳测得的平滑往返时延
 }
 
-func (m *muxPeer) ID() bson.ObjectID    { return m.id }
-func (m *muxPeer) Host() string         { return m.host }
-func (m *muxPeer) Muxer() muxconn.Muxer { return m.mux }
-func (m *muxPeer) Info() Info           { return m.inf }
+func (m *muxPeer) ID() bson.ObjectID      { return m.id }
+func (m *muxPeer) Host() string           { return m.host }
+func (m *muxPeer) Muxer() muxconn.Muxer   { return m.mux }
+func (m *muxPeer) Info() Info             { return m.inf }
+func (m *muxPeer) ConnectedAt() time.Time { return m.connectAt }
+
+func (m *muxPeer) Stat() Stat {
+	rx, tx := m.mux.Traffic()
+	// NumStreams 先后读取累计数和活跃数，并发打开流时活跃数可能大于累计数。
+	// 先取活跃数再取累计数，累计数只增不减，保证快照中 ActiveStreams <= TotalStreams。
+	_, active := m.mux.NumStreams()
+	cumulative, _ := m.mux.NumStreams()
+	name, module := m.mux.Library()
+	st := Stat{
+		ReceiveBytes:  rx,
+		TransmitBytes: tx,
+		ActiveStreams: active,
+		TotalStreams:  cumulative,
+		ConnectedAt:   m.connectAt,
+		LocalAddr:     addrString(m.mux.Addr()),
+		RemoteAddr:    addrString(m.mux.RemoteAddr()),
+		LibraryName:   name,
+		LibraryModule: module,
+		SmoothedRTT:   time.Duration(m.srtt.Load()),
+	}
+	if at := m.aliveAt.Load(); at != 0 {
+		st.KeepaliveAt = time.Unix(0, at)
+	}
+	if rp, ok := m.mux.(rttProvider); ok {
+		st.SmoothedRTT = rp.SmoothedRTT()
+	}
+
+	return st
+}
+
+func (m *muxPeer) Keepalive(at time.Time, rtt time.Duration) {
+	m.aliveAt.Store(at.UnixNano())
+	if rtt <= 0 {
+		return
+	}
+
+	// RFC 6298: SRTT = 7/8 * SRTT + 1/8 * R
+	for {
+		old := m.srtt.Load()
+		srtt := int64(rtt)
+		if old != 0 {
+			srtt = old - old/8 + int64(rtt)/8
+		}
+		if m.srtt.CompareAndSwap(old, srtt) {
+			return
+		}
+	}
+}
 
 type Info struct {
 	Name     string `json:"name"`
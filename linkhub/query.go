@@ -0,0 +1,172 @@
+package linkhub
+
+import (
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+)
+
+// Query 节点查询条件，零值字段代表不限制该条件，多个条件之间为且的关系。
+//
+// 版本号的每一段都必须是 0-255 的整数，查询条件中的版本号无效时返回 InvalidSemverError；
+// 节点的 Info.Semver 无效时，该节点不匹配任何设置了版本范围的查询。
+type Query struct {
+	Goos      string // 操作系统，例如：linux
+	Goarch    string // 架构，例如：arm64
+	Name      string // 节点名
+	MinSemver string // 最小版本（包含），例如：1.2.0，预发布版本 1.2.0-rc 小于 1.2.0，不在范围内
+	MaxSemver string // 最大版本（不包含），例如：1.4.0，预发布版本 1.4.0-rc 小于 1.4.0，在范围内
+	Inet      string // 出口 IP 所在网段，例如：10.0.0.0/8，也可以是单个 IP
+	Hostname  string // 主机名匹配模式，语法同 path.Match，例如：web-*
+}
+
+// compiledQuery 预解析后的查询条件。
+type compiledQuery struct {
+	Query
+	minSemver, maxSemver uint64
+	hasMin, hasMax       bool
+	prefix               netip.Prefix
+	hostnameGlob         bool
+}
+
+func (q Query) compile() (*compiledQuery, error) {
+	cq := &compiledQuery{Query: q}
+	if v := q.MinSemver; v != "" {
+		num, err := semverNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		cq.minSemver, cq.hasMin = num, true
+	}
+	if v := q.MaxSemver; v != "" {
+		num, err := semverNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		cq.maxSemver, cq.hasMax = num, true
+	}
+	if inet := q.Inet; inet != "" {
+		prefix, err := parsePrefix(inet)
+		if err != nil {
+			return nil, err
+		}
+		cq.prefix = prefix
+	}
+	if pattern := q.Hostname; pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		cq.hostnameGlob = strings.ContainsAny(pattern, `*?[\`)
+	}
+
+	return cq, nil
+}
+
+// match 判断节点信息是否满足全部条件。
+func (cq *compiledQuery) match(inf Info) bool {
+	if cq.Goos != "" && cq.Goos != inf.Goos {
+		return false
+	}
+	if cq.Goarch != "" && cq.Goarch != inf.Goarch {
+		return false
+	}
+	if cq.Name != "" && cq.Name != inf.Name {
+		return false
+	}
+	if cq.hasMin || cq.hasMax {
+		num, err := semverNumber(inf.Semver)
+		if err != nil {
+			return false
+		}
+		if cq.hasMin && num < cq.minSemver {
+			return false
+		}
+		if cq.hasMax && num >= cq.maxSemver {
+			return false
+		}
+	}
+	if cq.prefix.IsValid() {
+		addr, err := netip.ParseAddr(inf.Inet)
+		if err != nil || !cq.prefix.Contains(addr.Unmap()) {
+			return false
+		}
+	}
+	if pattern := cq.Hostname; pattern != "" {
+		if matched, _ := path.Match(pattern, inf.Hostname); !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// semverNumber 将版本号转为可比较的数字，允许省略 v 前缀和末尾的版本段，例如：v1.4 视为 1.4.0。
+// model.Semver 的每一段只占一个字节，所以非数字或者大于 255 的版本段都是无效的。
+//
+// 比较规则与 semver 一致：构建元数据不参与比较，预发布版本排在对应的正式版本之前，
+// 即 1.4.0-rc < 1.4.0 < 1.4.1-alpha。model.ParseSemver 把预发布版本排在正式版本之后，
+// 所以这里为正式版本额外置位第 4 个字节，预发布标识只比较前 4 个字节。
+func semverNumber(v string) (uint64, error) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	v, _, _ = strings.Cut(v, "+")
+	core, suffix := v, ""
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		core, suffix = v[:i], v[i:]
+	}
+	if core == "" {
+		return 0, &InvalidSemverError{Version: v}
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return 0, &InvalidSemverError{Version: v}
+	}
+	for _, part := range parts {
+		if _, err := strconv.ParseUint(part, 10, 8); err != nil {
+			return 0, &InvalidSemverError{Version: v}
+		}
+	}
+	for len(parts) < 3 {
+		parts = append(parts, "0")
+	}
+	sem := model.ParseSemver(strings.Join(parts, ".") + suffix)
+	num := sem.Number
+	if suffix == "" {
+		num |= 1 << 32
+	}
+
+	return num, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr := prefix.Addr()
+	if addr.Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96)
+	}
+
+	return prefix.Masked(), nil
+}
+
+type InvalidSemverError struct {
+	Version string
+}
+
+func (e *InvalidSemverError) Error() string {
+	return "invalid semver: " + e.Version
+}
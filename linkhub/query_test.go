@@ -0,0 +1,85 @@
+package linkhub
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestSemverNumberOrder(t *testing.T) {
+	// 按照 semver 的优先级从低到高排列。
+	versions := []string{"1.3.9", "1.4.0-alpha", "1.4.0-rc", "v1.4", "1.4.0+build.7", "1.4.1-alpha", "1.4.1"}
+	for i := 1; i < len(versions); i++ {
+		prev, err := semverNumber(versions[i-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := semverNumber(versions[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prev > next {
+			t.Errorf("semverNumber(%q) > semverNumber(%q)", versions[i-1], versions[i])
+		}
+	}
+}
+
+func TestFindSemverPrerelease(t *testing.T) {
+	hub := NewHub("example.com")
+	for i, v := range []string{"1.3.0", "1.4.0-rc", "1.4.0"} {
+		inf := stubInfo(i)
+		inf.Semver = v
+		hub.Put(bson.NewObjectID(), newStubMuxer(i), inf, time.Time{})
+	}
+
+	peers, err := hub.Find(Query{MaxSemver: "1.4.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 {
+		t.Fatalf("Find(MaxSemver=1.4.0) = %d peers, want 2", len(peers))
+	}
+	peers, _ = hub.Find(Query{MinSemver: "1.4.0"})
+	if len(peers) != 1 || peers[0].Info().Semver != "1.4.0" {
+		t.Fatalf("Find(MinSemver=1.4.0) = %v, want only 1.4.0", peers)
+	}
+}
+
+func TestFindInvalidSemver(t *testing.T) {
+	hub := NewHub("example.com")
+	for i, v := range []string{"1.0.0", "1.256.0", "1.x.0", "1.2.0"} {
+		inf := stubInfo(i)
+		inf.Semver = v
+		hub.Put(bson.NewObjectID(), newStubMuxer(i), inf, time.Time{})
+	}
+
+	for _, v := range []string{"1.x.0", "1.256.0", "x", "1..0", "-1.0.0", "1.2.3.4"} {
+		_, err := hub.Find(Query{MinSemver: v})
+		var ise *InvalidSemverError
+		if !errors.As(err, &ise) {
+			t.Errorf("Find(MinSemver=%q) error = %v, want InvalidSemverError", v, err)
+		}
+		if _, err = hub.Find(Query{MaxSemver: v}); !errors.As(err, &ise) {
+			t.Errorf("Find(MaxSemver=%q) error = %v, want InvalidSemverError", v, err)
+		}
+	}
+
+	// 版本号无效的节点不匹配版本范围查询，1.256.0 不会被当成 1.0.0。
+	peers, err := hub.Find(Query{MinSemver: "1.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 {
+		t.Fatalf("Find(MinSemver=1.0.0) = %d peers, want 2", len(peers))
+	}
+	for _, p := range peers {
+		if v := p.Info().Semver; v != "1.0.0" && v != "1.2.0" {
+			t.Fatalf("Find(MinSemver=1.0.0) matched invalid version %q", v)
+		}
+	}
+	if peers, _ = hub.Find(Query{}); len(peers) != 4 {
+		t.Fatalf("Find() = %d peers, want 4", len(peers))
+	}
+}
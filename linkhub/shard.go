@@ -0,0 +1,143 @@
+package linkhub
+
+import (
+	"sync"
+	"time"
+)
+
+func newPeerShard(size int) *peerShard {
+	return &peerShard{
+		peers: make(map[string]Peer, size),
+		index: newPeerIndex(),
+	}
+}
+
+// peerShard 一组受同一把锁保护的节点及其索引。
+type peerShard struct {
+	mutex sync.RWMutex
+	peers map[string]Peer
+	index *peerIndex
+}
+
+func (ps *peerShard) put(peer *muxPeer, core *hubCore) (Peer, PutResult) {
+	host := peer.host
+
+	ps.mutex.Lock()
+	if core.draining.Load() {
+		ps.mutex.Unlock()
+		return nil, PutDraining
+	}
+	old, exists := ps.peers[host]
+	if exists && !core.takeover.replace(old, peer) {
+		ps.mutex.Unlock()
+		return nil, PutRejected
+	}
+	ps.peers[host] = peer
+	if exists {
+		ps.index.remove(old)
+	}
+	ps.index.add(peer)
+	if exists {
+		core.events.publish(Event{Type: EventReplace, Peer: peer, Info: peer.inf, Old: old})
+	} else {
+		core.events.publish(Event{Type: EventJoin, Peer: peer, Info: peer.inf})
+	}
+	ps.mutex.Unlock()
+
+	if !exists {
+		core.connected(peer)
+		return peer, PutJoined
+	}
+	core.disconnected(old, time.Now())
+	_ = old.Muxer().Close()
+	core.connected(peer)
+
+	return peer, PutReplaced
+}
+
+func (ps *peerShard) get(host string) Peer {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	return ps.peers[host]
+}
+
+func (ps *peerShard) del(host string, core *hubCore) Peer {
+	ps.mutex.Lock()
+	peer := ps.peers[host]
+	if peer == nil {
+		ps.mutex.Unlock()
+		return nil
+	}
+	delete(ps.peers, host)
+	ps.index.remove(peer)
+	core.events.publish(Event{Type: EventLeave, Peer: peer, Info: peer.Info()})
+	ps.mutex.Unlock()
+
+	core.disconnected(peer, time.Now())
+
+	return peer
+}
+
+func (ps *peerShard) delPeer(p Peer, core *hubCore) bool {
+	host := p.Host()
+
+	ps.mutex.Lock()
+	if ps.peers[host] != p {
+		ps.mutex.Unlock()
+		return false
+	}
+	delete(ps.peers, host)
+	ps.index.remove(p)
+	core.events.publish(Event{Type: EventLeave, Peer: p, Info: p.Info()})
+	ps.mutex.Unlock()
+
+	core.disconnected(p, time.Now())
+
+	return true
+}
+
+// migrate 节点的远端地址变化时记录迁移，节点不在分片中或者地址没有变化时返回 false。
+func (ps *peerShard) migrate(p Peer, core *hubCore) bool {
+	mp, ok := p.(*muxPeer)
+	if !ok {
+		return false
+	}
+	to := addrString(mp.mux.RemoteAddr())
+
+	ps.mutex.Lock()
+	from := mp.remote
+	if ps.peers[mp.host] != p || from == to || to == "" {
+		ps.mutex.Unlock()
+		return false
+	}
+	mp.remote = to
+	now := time.Now()
+	core.events.publish(Event{Type: EventMigrate, Peer: p, Info: mp.inf, From: from, To: to, At: now})
+	ps.mutex.Unlock()
+
+	core.migrated(p, from, to, now)
+
+	return true
+}
+
+func (ps *peerShard) len() int {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	return len(ps.peers)
+}
+
+func (ps *peerShard) appendTo(dst []Peer) []Peer {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	if dst == nil {
+		dst = make([]Peer, 0, len(ps.peers))
+	}
+	for _, peer := range ps.peers {
+		dst = append(dst, peer)
+	}
+
+	return dst
+}
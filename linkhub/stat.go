@@ -0,0 +1,49 @@
+package linkhub
+
+import (
+	"net"
+	"time"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+)
+
+// Stat 节点通道的统计快照，流量以本端（连接池所在进程）为主体。
+type Stat struct {
+	ReceiveBytes  uint64        `json:"receive_bytes"`         // 本端接收字节数
+	TransmitBytes uint64        `json:"transmit_bytes"`        // 本端发送字节数
+	ActiveStreams int64         `json:"active_streams"`        // 当前活跃的流数
+	TotalStreams  int64         `json:"total_streams"`         // 累计打开过的流数
+	ConnectedAt   time.Time     `json:"connected_at"`          // 通道建立时间
+	KeepaliveAt   time.Time     `json:"keepalive_at,omitzero"` // 最近一次心跳时间
+	SmoothedRTT   time.Duration `json:"smoothed_rtt,omitzero"` // 平滑往返时延，0 代表未知
+	LocalAddr     string        `json:"local_addr,omitzero"`
+	RemoteAddr    string        `json:"remote_addr,omitzero"`
+	LibraryName   string        `json:"library_name,omitzero"`
+	LibraryModule string        `json:"library_module,omitzero"`
+}
+
+// TunnelStat 转为数据库中的通道状态，数据库中的流量以 broker/agent 为主体，所以收发方向与 Stat 相反。
+func (s Stat) TunnelStat() *model.TunnelStat {
+	return &model.TunnelStat{
+		ConnectedAt:   s.ConnectedAt,
+		KeepaliveAt:   s.KeepaliveAt,
+		Library:       model.TunnelLibrary{Name: s.LibraryName, Module: s.LibraryModule},
+		LocalAddr:     s.LocalAddr,
+		RemoteAddr:    s.RemoteAddr,
+		ReceiveBytes:  s.TransmitBytes,
+		TransmitBytes: s.ReceiveBytes,
+	}
+}
+
+// rttProvider 底层通道如果能提供往返时延则优先使用。
+type rttProvider interface {
+	SmoothedRTT() time.Duration
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	return addr.String()
+}
@@ -0,0 +1,154 @@
+package linkhub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// TestStatStreams 流量和流数随真实的流变化，TunnelStat 的收发方向与 Stat 相反。
+func TestStatStreams(t *testing.T) {
+	local, remote := pipeMuxers(t)
+	go serveEcho(remote)
+
+	hub := NewHub("example.com")
+	connectAt := time.Now().Add(-time.Minute)
+	p, _ := hub.Put(bson.NewObjectID(), local, stubInfo(1), connectAt)
+
+	st := p.Stat()
+	if st.ActiveStreams != 0 || st.TotalStreams != 0 || !st.ConnectedAt.Equal(connectAt) || st.LibraryName == "" {
+		t.Fatalf("initial stat = %+v", st)
+	}
+	rx0, tx0 := st.ReceiveBytes, st.TransmitBytes
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := local.Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := bytes.Repeat([]byte("x"), 32<<10)
+	written := make(chan error, 1)
+	go func() {
+		_, exx := stream.Write(payload)
+		written <- exx
+	}()
+	if _, err = io.ReadFull(stream, make([]byte, len(payload))); err != nil {
+		t.Fatal(err)
+	}
+	// 发送计数在 Write 返回时才确定，读完回显后还需要等待写入结束。
+	if err = <-written; err != nil {
+		t.Fatal(err)
+	}
+
+	st = p.Stat()
+	if st.ActiveStreams != 1 || st.TotalStreams != 1 {
+		t.Fatalf("streams active=%d total=%d while open, want 1 and 1", st.ActiveStreams, st.TotalStreams)
+	}
+	size := uint64(len(payload))
+	if st.TransmitBytes-tx0 < size || st.ReceiveBytes-rx0 < size {
+		t.Fatalf("traffic rx=%d tx=%d, want at least %d each way", st.ReceiveBytes-rx0, st.TransmitBytes-tx0, size)
+	}
+	ts := st.TunnelStat()
+	if ts.ReceiveBytes != st.TransmitBytes || ts.TransmitBytes != st.ReceiveBytes {
+		t.Fatalf("TunnelStat did not swap directions: %+v", ts)
+	}
+
+	_ = stream.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Stat().ActiveStreams != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("active streams not released after close")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := p.Stat().TotalStreams; n != 1 {
+		t.Fatalf("total streams = %d after close, want 1", n)
+	}
+}
+
+// TestStatSnapshot 并发读写时快照中的累计值不回退，活跃流数不超过累计流数。
+func TestStatSnapshot(t *testing.T) {
+	local, remote := pipeMuxers(t)
+	go serveEcho(remote)
+
+	hub := NewHub("example.com")
+	p, _ := hub.Put(bson.NewObjectID(), local, stubInfo(1), time.Time{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	const workers = 8
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			for range 10 {
+				stream, err := local.Open(ctx)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				msg := bytes.Repeat([]byte("y"), 4096)
+				go func() { _, _ = stream.Write(msg) }()
+				_, _ = io.ReadFull(stream, make([]byte, len(msg)))
+				_ = stream.Close()
+			}
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var last Stat
+	var failure string
+	for running := true; running && failure == ""; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		st := p.Stat()
+		if st.ReceiveBytes < last.ReceiveBytes || st.TransmitBytes < last.TransmitBytes || st.TotalStreams < last.TotalStreams {
+			failure = fmt.Sprintf("stat went backwards: %+v after %+v", st, last)
+		}
+		if st.ActiveStreams < 0 || st.ActiveStreams > st.TotalStreams {
+			failure = fmt.Sprintf("active streams %d out of range, total %d", st.ActiveStreams, st.TotalStreams)
+		}
+		last = st
+	}
+	cancel()
+	<-done
+	if failure != "" {
+		t.Fatal(failure)
+	}
+	if n := p.Stat().TotalStreams; n != workers*10 {
+		t.Fatalf("total streams = %d, want %d", n, workers*10)
+	}
+}
+
+func TestStatKeepalive(t *testing.T) {
+	hub := NewHub("example.com")
+	p, _ := hub.Put(bson.NewObjectID(), newStubMuxer(1), stubInfo(1), time.Time{})
+	if st := p.Stat(); !st.KeepaliveAt.IsZero() || st.SmoothedRTT != 0 {
+		t.Fatalf("stat before keepalive = %+v", st)
+	}
+
+	at := time.Now()
+	p.Keepalive(at, 80*time.Millisecond)
+	p.Keepalive(at.Add(time.Second), 160*time.Millisecond)
+	p.Keepalive(at.Add(2*time.Second), 0) // 未测量的时延不参与平滑
+	st := p.Stat()
+	if !st.KeepaliveAt.Equal(at.Add(2 * time.Second)) {
+		t.Fatalf("KeepaliveAt = %s", st.KeepaliveAt)
+	}
+	if want := 90 * time.Millisecond; st.SmoothedRTT != want {
+		t.Fatalf("SmoothedRTT = %s, want %s", st.SmoothedRTT, want)
+	}
+}
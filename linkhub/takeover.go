@@ -0,0 +1,60 @@
+package linkhub
+
+// TakeoverPolicy 同一 ID 的节点在旧连接仍然在线时重新连接的接管策略。
+type TakeoverPolicy int
+
+const (
+	TakeoverReject  TakeoverPolicy = iota // 拒绝新连接，保留旧节点（默认）
+	TakeoverReplace                       // 新连接替换旧节点，并关闭旧节点的通道
+	TakeoverNewest                        // 保留连接时间较新的节点
+)
+
+func (p TakeoverPolicy) String() string {
+	switch p {
+	case TakeoverReject:
+		return "reject"
+	case TakeoverReplace:
+		return "replace"
+	case TakeoverNewest:
+		return "newest"
+	default:
+		return "unknown"
+	}
+}
+
+// replace 判断新节点是否应该替换旧节点。
+func (p TakeoverPolicy) replace(old, peer Peer) bool {
+	switch p {
+	case TakeoverReplace:
+		return true
+	case TakeoverNewest:
+		return peer.ConnectedAt().After(old.ConnectedAt())
+	default:
+		return false
+	}
+}
+
+// PutResult 节点加入连接池的结果。
+type PutResult int
+
+const (
+	PutJoined   PutResult = iota + 1 // 新节点加入，此前不存在同 ID 节点
+	PutReplaced                      // 新节点替换了旧节点，旧节点通道已关闭
+	PutRejected                      // 新节点被拒绝，调用方负责关闭新节点通道
+	PutDraining                      // 连接池正在排空，调用方负责关闭新节点通道
+)
+
+func (r PutResult) String() string {
+	switch r {
+	case PutJoined:
+		return "joined"
+	case PutReplaced:
+		return "replaced"
+	case PutRejected:
+		return "rejected"
+	case PutDraining:
+		return "draining"
+	default:
+		return "unknown"
+	}
+}
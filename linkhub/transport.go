@@ -0,0 +1,88 @@
+package linkhub
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xmx/aegis-common/problem"
+)
+
+// NewTransport 经由连接池中节点通道发送请求的 http.RoundTripper，
+// 请求的 Host 为节点的主机名：<objectid>.<domain>，可以配合 httpnet.NewReverse 反向代理节点上的 HTTP 服务。
+//
+// 节点不存在或者通道已断开时返回 *problem.Details 错误。
+func NewTransport(hub Huber) http.RoundTripper {
+	ht := &hubTransport{hub: hub}
+	ht.tran = &http.Transport{
+		DialContext:           ht.dialContext,
+		MaxConnsPerHost:       50,
+		IdleConnTimeout:       3 * time.Minute,
+		ResponseHeaderTimeout: time.Minute,
+	}
+
+	return ht
+}
+
+type hubTransport struct {
+	hub  Huber
+	tran *http.Transport
+}
+
+func (ht *hubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	host := ht.hostname(r.URL.Host)
+	if ht.hub.Get(host) == nil {
+		return nil, ht.problem(r, http.StatusNotFound, "节点不存在或未上线："+host)
+	}
+
+	res, err := ht.tran.RoundTrip(r)
+	if err != nil {
+		var pd *problem.Details
+		if errors.As(err, &pd) {
+			return nil, ht.problem(r, pd.Status, pd.Detail)
+		}
+		return nil, ht.problem(r, http.StatusBadGateway, err.Error())
+	}
+
+	return res, nil
+}
+
+func (ht *hubTransport) dialContext(ctx context.Context, _, address string) (net.Conn, error) {
+	host := ht.hostname(address)
+	peer := ht.hub.Get(host)
+	if peer == nil {
+		return nil, &problem.Details{Host: host, Status: http.StatusNotFound, Detail: "节点不存在或未上线：" + host}
+	}
+
+	conn, err := peer.Muxer().Open(ctx)
+	if err != nil {
+		return nil, &problem.Details{Host: host, Status: http.StatusBadGateway, Detail: "节点通道已断开：" + err.Error()}
+	}
+
+	return conn, nil
+}
+
+func (ht *hubTransport) hostname(address string) string {
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+
+	return strings.ToLower(host)
+}
+
+func (ht *hubTransport) problem(r *http.Request, code int, detail string) *problem.Details {
+	return &problem.Details{
+		Host:     r.Host,
+		Type:     r.Host,
+		Title:    http.StatusText(code),
+		Status:   code,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Method:   r.Method,
+		Datetime: time.Now().UTC(),
+	}
+}
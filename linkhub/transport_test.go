@@ -0,0 +1,156 @@
+package linkhub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xmx/aegis-common/problem"
+	"github.com/xmx/aegis-control/library/httpnet"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// countMuxer 记录 Open 次数，用于确认请求没有向节点拨号。
+type countMuxer struct {
+	stubMuxer
+	opens *atomic.Int64
+}
+
+func (m countMuxer) Open(context.Context) (net.Conn, error) {
+	m.opens.Add(1)
+	return nil, errors.New("unexpected dial")
+}
+
+// servePeer 将一对内存 smux 通道的客户端放入连接池，服务端返回节点名称。
+func servePeer(t *testing.T, hub Huber, name string) Peer {
+	t.Helper()
+	srv, cli := pipeMuxers(t)
+	go func() {
+		_ = http.Serve(srv, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name+" "+r.URL.Path)
+		}))
+	}()
+
+	p, _ := hub.Put(bson.NewObjectID(), cli, stubInfo(1), time.Time{})
+	return p
+}
+
+func readBody(t *testing.T, res *http.Response) string {
+	t.Helper()
+	//goland:noinspection GoUnhandledErrorResult
+	defer res.Body.Close()
+
+	dat, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(dat)
+}
+
+func TestTransportRoute(t *testing.T) {
+	hub := NewHub("example.com")
+	peers := map[string]Peer{
+		"alpha": servePeer(t, hub, "alpha"),
+		"bravo": servePeer(t, hub, "bravo"),
+	}
+	cli := &http.Client{Transport: NewTransport(hub), Timeout: 5 * time.Second}
+
+	for round := 0; round < 2; round++ {
+		for name, p := range peers {
+			res, err := cli.Get("http://" + p.Host() + "/api/ping")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body := readBody(t, res); body != name+" /api/ping" {
+				t.Fatalf("%s got %q", p.Host(), body)
+			}
+		}
+	}
+}
+
+func TestTransportUnknownHost(t *testing.T) {
+	hub := NewHub("example.com")
+	var opens atomic.Int64
+	known, _ := hub.Put(bson.NewObjectID(), countMuxer{stubMuxer: newStubMuxer(1), opens: &opens}, stubInfo(1), time.Time{})
+
+	tran := NewTransport(hub)
+	hosts := []string{
+		bson.NewObjectID().Hex() + ".example.com",
+		known.ID().Hex() + ".example.org",
+		"localhost",
+	}
+	for _, host := range hosts {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/api/ping", nil)
+		res, err := tran.RoundTrip(req)
+		if res != nil {
+			_ = res.Body.Close()
+		}
+		var pd *problem.Details
+		if !errors.As(err, &pd) || pd.Status != http.StatusNotFound {
+			t.Fatalf("%s: err = %v, want 404 problem", host, err)
+		}
+	}
+	if n := opens.Load(); n != 0 {
+		t.Fatalf("unknown hosts dialed %d times", n)
+	}
+
+	// 节点存在但通道不可用时返回 502。
+	req := httptest.NewRequest(http.MethodGet, "http://"+known.Host()+"/", nil)
+	_, err := tran.RoundTrip(req)
+	var pd *problem.Details
+	if !errors.As(err, &pd) || pd.Status != http.StatusBadGateway {
+		t.Fatalf("err = %v, want 502 problem", err)
+	}
+	if opens.Load() == 0 {
+		t.Fatal("known peer was not dialed")
+	}
+}
+
+func TestTransportReverse(t *testing.T) {
+	hub := NewHub("example.com")
+	p := servePeer(t, hub, "alpha")
+
+	// 按照入站请求的 Host 反向代理到对应节点。
+	proxy := httpnet.NewReverse(NewTransport(hub))
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Scheme, r.URL.Host = "http", r.Host
+		proxy.ServeHTTP(w, r)
+	}))
+	defer front.Close()
+
+	get := func(host string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, front.URL+"/api/ping", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = host
+		res, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := get(p.Host())
+	if body := readBody(t, res); res.StatusCode != http.StatusOK || body != "alpha /api/ping" {
+		t.Fatalf("status = %d, body = %q", res.StatusCode, body)
+	}
+
+	res = get(bson.NewObjectID().Hex() + ".example.com")
+	body := readBody(t, res)
+	var pd problem.Details
+	if err := json.Unmarshal([]byte(body), &pd); err != nil {
+		t.Fatalf("body %q: %v", body, err)
+	}
+	if res.StatusCode != http.StatusNotFound || pd.Status != http.StatusNotFound {
+		t.Fatalf("status = %d, problem = %v", res.StatusCode, pd)
+	}
+}
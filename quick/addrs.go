@@ -0,0 +1,50 @@
+package quick
+
+import (
+	"net"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+)
+
+// ListenAddrs 根据 broker 配置计算需要监听的地址，结果可以直接传给 Server.SetAddrs。
+//
+// cfg.Addr 原样监听；暴露地址是客户端连接使用的域名或公网地址，本机不一定能绑定，
+// 所以只取其端口监听所有网卡。0.0.0.0 和 :: 统一视为所有网卡，
+// 同一端口只保留一个地址，所有网卡优先，避免重复监听同一端口。
+func ListenAddrs(cfg model.BrokerServerConfig, exposes model.ExposeAddresses) []string {
+	ports := make([]string, 0, len(exposes)+1)
+	hosts := make(map[string]string, len(exposes)+1) // port -> host
+	add := func(host, port string) {
+		if host == "0.0.0.0" || host == "::" {
+			host = ""
+		}
+		if _, exists := hosts[port]; !exists {
+			ports = append(ports, port)
+			hosts[port] = host
+		} else if host == "" {
+			hosts[port] = ""
+		}
+	}
+
+	var invalid string // 无法解析的 cfg.Addr 原样返回，由 SetAddrs 报告监听错误
+	if host, port, err := net.SplitHostPort(cfg.Addr); err == nil && port != "" {
+		add(host, port)
+	} else {
+		invalid = cfg.Addr
+	}
+	for _, addr := range exposes.Addresses() {
+		if _, port, err := net.SplitHostPort(addr); err == nil && port != "" {
+			add("", port)
+		}
+	}
+
+	rets := make([]string, 0, len(ports)+1)
+	if invalid != "" {
+		rets = append(rets, invalid)
+	}
+	for _, port := range ports {
+		rets = append(rets, net.JoinHostPort(hosts[port], port))
+	}
+
+	return rets
+}
@@ -0,0 +1,39 @@
+package quick
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+)
+
+func TestListenAddrs(t *testing.T) {
+	exposes := func(addrs ...string) model.ExposeAddresses {
+		rets := make(model.ExposeAddresses, 0, len(addrs))
+		for _, addr := range addrs {
+			rets = append(rets, &model.ExposeAddress{Name: addr, Addr: addr})
+		}
+		return rets
+	}
+
+	cases := []struct {
+		name    string
+		addr    string
+		exposes model.ExposeAddresses
+		want    []string
+	}{
+		{name: "wildcard", addr: "0.0.0.0:443", exposes: exposes("broker.example.com:443"), want: []string{":443"}},
+		{name: "ipv6 wildcard", addr: "[::]:443", exposes: exposes("1.2.3.4:443", "1.2.3.4:8443"), want: []string{":443", ":8443"}},
+		{name: "specific host", addr: "10.0.0.1:443", exposes: exposes("broker.example.com:443"), want: []string{":443"}},
+		{name: "other port", addr: "127.0.0.1:9443", exposes: exposes("broker.example.com:443"), want: []string{"127.0.0.1:9443", ":443"}},
+		{name: "empty", exposes: exposes("broker.example.com"), want: []string{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ListenAddrs(model.BrokerServerConfig{Addr: tc.addr}, tc.exposes)
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("ListenAddrs() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+	"github.com/xmx/aegis-control/datalayer/repository"
+	"github.com/xmx/metrics"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"golang.org/x/time/rate"
+)
+
+// 连接被拒绝的原因。
+const (
+	RejectFirewall    = "firewall"         // 防火墙拒绝
+	RejectRate        = "rate"             // 来源 IP 新建连接过快
+	RejectMaxConns    = "max_conns"        // 超过全局最大连接数
+	RejectMaxConnsIP  = "max_conns_per_ip" // 超过单 IP 最大连接数
+	RejectInvalidAddr = "invalid_addr"     // 无法解析来源地址
+)
+
+// AdmissionConfig 连接准入控制配置，字段为零值时表示不限制。
+type AdmissionConfig struct {
+	MaxConns      int          // 全局最大并发连接数
+	MaxConnsPerIP int          // 单个来源 IP 最大并发连接数
+	RatePerIP     rate.Limit   // 单个来源 IP 每秒允许新建的连接数
+	BurstPerIP    int          // 单个来源 IP 新建连接的突发数，小于等于 0 时默认为 1
+	Firewall      FirewallFunc // 握手前检查来源 IP 是否允许连接
+	Metrics       *metrics.Set // 指标注册位置，为 nil 时使用 metrics.GetDefaultSet()
+	Name          string       // 指标的 server 标签，区分同一进程中的多个服务，为空时使用 New 的监听地址，同名服务的指标合并统计
+}
+
+// FirewallFunc 检查来源 IP 是否允许连接。
+type FirewallFunc func(ip netip.Addr) bool
+
+type RejectError struct {
+	Reason string
+	Addr   net.Addr
+}
+
+func (e *RejectError) Error() string {
+	addr := "<nil>"
+	if e.Addr != nil {
+		addr = e.Addr.String()
+	}
+
+	return "connection from " + addr + " rejected: " + e.Reason
+}
+
+func newAdmission(cfg AdmissionConfig, addr string) *admission {
+	if cfg.BurstPerIP <= 0 {
+		cfg.BurstPerIP = 1
+	}
+	if cfg.Name == "" {
+		cfg.Name = addr
+	}
+	set := cfg.Metrics
+	if set == nil {
+		set = metrics.GetDefaultSet()
+	}
+
+	adm := &admission{
+		cfg:     cfg,
+		ips:     make(map[netip.Addr]*ipState, 1024),
+		metrics: set,
+		label:   "server=" + strconv.Quote(cfg.Name),
+	}
+	// 指标按值更新而不是注册回调，同名的服务共享同一个指标，取值为它们的连接数之和，
+	// 不会引用到已经停止的服务。
+	adm.gauge = set.GetOrCreateGauge("quick_admission_active_conns{"+adm.label+"}", nil)
+
+	return adm
+}
+
+// admission 连接准入控制。
+//
+// 防火墙和频率检查尽量放在握手之前（early），连接数在握手完成后占用（acquire），
+// 直到连接处理结束后释放。
+type admission struct {
+	cfg     AdmissionConfig
+	metrics *metrics.Set
+	label   string         // 指标标签
+	gauge   *metrics.Gauge // 当前连接数指标
+	active  atomic.Int64   // 只在持有 mutex 时修改
+	mutex   sync.Mutex
+	ips     map[netip.Addr]*ipState
+	sweepAt time.Time
+}
+
+type ipState struct {
+	conns   int
+	limiter *rate.Limiter
+	seenAt  time.Time
+}
+
+// early 握手前的检查：防火墙和新建连接频率。
+func (a *admission) early(addr net.Addr) error {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return a.reject(RejectInvalidAddr, addr)
+	}
+	if fw := a.cfg.Firewall; fw != nil && !fw(ip) {
+		return a.reject(RejectFirewall, addr)
+	}
+	if a.cfg.RatePerIP <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	a.mutex.Lock()
+	st := a.state(ip, now)
+	allowed := st.limiter.AllowN(now, 1)
+	a.mutex.Unlock()
+	if !allowed {
+		return a.reject(RejectRate, addr)
+	}
+
+	return nil
+}
+
+// acquire 握手后占用连接数，返回的 release 需要在连接处理结束后调用。
+func (a *admission) acquire(addr net.Addr) (func(), error) {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return nil, a.reject(RejectInvalidAddr, addr)
+	}
+
+	// 检查和占用在同一把锁内完成，并发握手不会越过上限。
+	now := time.Now()
+	a.mutex.Lock()
+	if limit := a.cfg.MaxConns; limit > 0 && a.active.Load() >= int64(limit) {
+		a.mutex.Unlock()
+		return nil, a.reject(RejectMaxConns, addr)
+	}
+	st := a.state(ip, now)
+	if limit := a.cfg.MaxConnsPerIP; limit > 0 && st.conns >= limit {
+		a.mutex.Unlock()
+		return nil, a.reject(RejectMaxConnsIP, addr)
+	}
+	st.conns++
+	a.active.Add(1)
+	a.mutex.Unlock()
+	a.gauge.Inc()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			a.mutex.Lock()
+			a.active.Add(-1)
+			st.conns--
+			st.seenAt = time.Now()
+			a.mutex.Unlock()
+			a.gauge.Dec()
+		})
+	}
+
+	return release, nil
+}
+
+// state 获取来源 IP 的状态，必须在持有锁时调用。
+func (a *admission) state(ip netip.Addr, now time.Time) *ipState {
+	if now.Sub(a.sweepAt) > time.Minute {
+		a.sweepAt = now
+		for k, v := range a.ips {
+			if v.conns <= 0 && now.Sub(v.seenAt) > time.Minute {
+				delete(a.ips, k)
+			}
+		}
+	}
+
+	st := a.ips[ip]
+	if st == nil {
+		st = &ipState{limiter: rate.NewLimiter(a.cfg.RatePerIP, a.cfg.BurstPerIP)}
+		if a.cfg.RatePerIP <= 0 {
+			st.limiter.SetLimit(rate.Inf)
+		}
+		a.ips[ip] = st
+	}
+	st.seenAt = now
+
+	return st
+}
+
+func (a *admission) reject(reason string, addr net.Addr) error {
+	a.metrics.GetOrCreateCounter(`quick_admission_rejected_total{` + a.label + `,reason="` + reason + `"}`).Inc()
+	return &RejectError{Reason: reason, Addr: addr}
+}
+
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip, ok := netip.AddrFromSlice(a.IP)
+		return ip.Unmap(), ok
+	case *net.TCPAddr:
+		ip, ok := netip.AddrFromSlice(a.IP)
+		return ip.Unmap(), ok
+	case nil:
+		return netip.Addr{}, false
+	}
+
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return ap.Addr().Unmap(), true
+}
+
+// FirewallRule 将 model.Firewall 的 IP 名单转换为 FirewallFunc，名单中可以是 IP 或 CIDR。
+// 规则为 nil 或者未启用时放行所有来源，暂不支持国家（地区）模式。
+func FirewallRule(fw *model.Firewall) (FirewallFunc, error) {
+	if fw == nil || !fw.Enabled {
+		return func(netip.Addr) bool { return true }, nil
+	}
+	if fw.CountryMode {
+		return nil, errors.New("firewall country mode is not supported")
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(fw.IPNets))
+	for _, s := range fw.IPNets {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if pfx, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, pfx.Masked())
+			continue
+		}
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		ip = ip.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+
+	blacklist := fw.Blacklist
+	return func(ip netip.Addr) bool {
+		for _, pfx := range prefixes {
+			if pfx.Contains(ip) {
+				return !blacklist
+			}
+		}
+		return blacklist
+	}, nil
+}
+
+// NewFirewallLoader 从数据库加载当前生效的防火墙规则，调用 Reload 刷新规则，
+// Allow 可以作为 AdmissionConfig.Firewall 使用。规则加载失败时保留上一次的规则。
+func NewFirewallLoader(repo repository.Firewall) *FirewallLoader {
+	fl := &FirewallLoader{repo: repo}
+	allow := FirewallFunc(func(netip.Addr) bool { return true })
+	fl.rule.Store(&allow)
+
+	return fl
+}
+
+type FirewallLoader struct {
+	repo repository.Firewall
+	rule atomic.Pointer[FirewallFunc]
+}
+
+func (fl *FirewallLoader) Allow(ip netip.Addr) bool {
+	return (*fl.rule.Load())(ip)
+}
+
+// Reload 重新加载防火墙规则。
+func (fl *FirewallLoader) Reload(ctx context.Context) error {
+	fw, err := fl.repo.Enabled(ctx)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	rule, err := FirewallRule(fw)
+	if err != nil {
+		return err
+	}
+	fl.rule.Store(&rule)
+
+	return nil
+}
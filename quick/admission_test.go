@@ -0,0 +1,88 @@
+package quick
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xmx/metrics"
+)
+
+func TestAdmissionMaxConns(t *testing.T) {
+	set := metrics.NewSet()
+	adm := newAdmission(AdmissionConfig{MaxConns: 10, Metrics: set}, "127.0.0.1:443")
+
+	var wg sync.WaitGroup
+	var admitted atomic.Int64
+	start := make(chan struct{})
+	for i := range 200 {
+		wg.Go(func() {
+			<-start
+			addr := &net.UDPAddr{IP: net.IPv4(10, 0, byte(i>>8), byte(i)), Port: 443}
+			if _, err := adm.acquire(addr); err == nil {
+				admitted.Add(1)
+			}
+		})
+	}
+	close(start)
+	wg.Wait()
+
+	if n := admitted.Load(); n != 10 {
+		t.Fatalf("admitted %d connections, want 10", n)
+	}
+
+	buf := new(bytes.Buffer)
+	set.WritePrometheus(buf)
+	for _, want := range []string{
+		`quick_admission_active_conns{server="127.0.0.1:443"} 10`,
+		`quick_admission_rejected_total{server="127.0.0.1:443",reason="max_conns"} 190`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("metrics missing %q:\n%s", want, buf)
+		}
+	}
+}
+
+// TestAdmissionSharedName 同名的服务共享连接数指标，旧服务停止后指标不再包含它的连接。
+func TestAdmissionSharedName(t *testing.T) {
+	set := metrics.NewSet()
+	cfg := AdmissionConfig{Metrics: set, Name: "tunnel"}
+	const metric = `quick_admission_active_conns{server="tunnel"}`
+	active := func() string {
+		buf := new(bytes.Buffer)
+		set.WritePrometheus(buf)
+		for line := range strings.Lines(buf.String()) {
+			if name, value, ok := strings.Cut(strings.TrimSpace(line), " "); ok && name == metric {
+				return value
+			}
+		}
+		t.Fatalf("metrics missing %s:\n%s", metric, buf)
+		return ""
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 443}
+
+	old := newAdmission(cfg, "127.0.0.1:443")
+	release, err := old.acquire(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cur := newAdmission(cfg, "127.0.0.1:443")
+	for range 2 {
+		if _, err = cur.acquire(addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := active(); v != "3" {
+		t.Fatalf("active conns = %s, want 3", v)
+	}
+
+	release()
+	release()
+	if v := active(); v != "2" {
+		t.Fatalf("active conns = %s after the old server released, want 2", v)
+	}
+}
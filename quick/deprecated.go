@@ -0,0 +1,96 @@
+package quick
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/xmx/aegis-common/muxlink/muxproto"
+	xquic "golang.org/x/net/quic"
+)
+
+// QUICgo 基于 github.com/quic-go/quic-go 的服务。
+//
+// Deprecated: 使用 New 和 WithBackend(BackendQUICgo)，QUICgo 只是转调 Server 的兼容层。
+type QUICgo struct {
+	Addr       string
+	Handler    muxproto.MUXAccepter
+	TLSConfig  *tls.Config
+	QUICConfig *quic.Config
+	shim       serverShim
+}
+
+func (q *QUICgo) Close() error {
+	return q.shim.close()
+}
+
+func (q *QUICgo) ListenAndServe(ctx context.Context) error {
+	srv := New(q.Addr,
+		WithBackend(BackendQUICgo),
+		WithHandler(q.Handler),
+		WithTLSConfig(q.TLSConfig),
+		WithQUICgoConfig(q.QUICConfig),
+	)
+
+	return q.shim.serve(ctx, srv)
+}
+
+// QUICx 基于 golang.org/x/net/quic 的服务，TLS 配置取自 QUICConfig.TLSConfig。
+//
+// Deprecated: 使用 New 和 WithBackend(BackendQUICx)，QUICx 只是转调 Server 的兼容层。
+type QUICx struct {
+	Addr       string
+	Accept     muxproto.MUXAccepter
+	QUICConfig *xquic.Config
+	shim       serverShim
+}
+
+func (q *QUICx) Close() error {
+	return q.shim.close()
+}
+
+func (q *QUICx) ListenAndServe(ctx context.Context) error {
+	opts := []Option{WithBackend(BackendQUICx), WithHandler(q.Accept), WithQUICxConfig(q.QUICConfig)}
+	if cfg := q.QUICConfig; cfg != nil {
+		opts = append(opts, WithTLSConfig(cfg.TLSConfig))
+	}
+
+	return q.shim.serve(ctx, New(q.Addr, opts...))
+}
+
+// serverShim 与旧版本一致，每次 ListenAndServe 都是独立的监听，Close 关闭所有正在运行的监听。
+type serverShim struct {
+	mutex   sync.Mutex
+	servers map[*Server]struct{}
+}
+
+func (s *serverShim) serve(ctx context.Context, srv *Server) error {
+	s.mutex.Lock()
+	if s.servers == nil {
+		s.servers = make(map[*Server]struct{}, 4)
+	}
+	s.servers[srv] = struct{}{}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.servers, srv)
+		s.mutex.Unlock()
+	}()
+
+	return srv.ListenAndServe(ctx)
+}
+
+func (s *serverShim) close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	errs := make([]error, 0, len(s.servers))
+	for srv := range s.servers {
+		errs = append(errs, srv.Close())
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,87 @@
+package quick_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/xmx/aegis-control/quick"
+)
+
+// freeUDPAddr 返回一个当前空闲的本地 UDP 地址。
+func freeUDPAddr(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	_ = pc.Close()
+
+	return addr
+}
+
+// TestQUICgoShim 兼容层原样使用调用方的 quic.Config，并且和旧版本一样可以重复 ListenAndServe。
+func TestQUICgoShim(t *testing.T) {
+	addr := freeUDPAddr(t)
+	srv := &quick.QUICgo{
+		Addr:       addr,
+		Handler:    echoAccepter{},
+		TLSConfig:  &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}},
+		QUICConfig: &quic.Config{EnableDatagrams: true, KeepAlivePeriod: time.Second},
+	}
+
+	for round := range 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		done := make(chan error, 1)
+		go func() { done <- srv.ListenAndServe(ctx) }()
+
+		conn := dialQUICgo(ctx, t, addr)
+		if !conn.ConnectionState().SupportsDatagrams.Remote {
+			t.Fatalf("round %d: EnableDatagrams from QUICConfig was not applied", round)
+		}
+		_ = conn.CloseWithError(0, "")
+
+		if err := srv.Close(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			t.Fatalf("round %d: ListenAndServe did not return after Close", round)
+		}
+		cancel()
+	}
+
+	// 兼容层无法支持的字段返回错误，而不是静默忽略。
+	bad := &quick.QUICgo{
+		Addr:      addr,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}},
+		QUICConfig: &quic.Config{GetConfigForClient: func(*quic.ClientInfo) (*quic.Config, error) {
+			return nil, nil
+		}},
+	}
+	if err := bad.ListenAndServe(context.Background()); err == nil {
+		t.Fatal("ListenAndServe accepted QUICConfig.GetConfigForClient")
+	}
+}
+
+// dialQUICgo 重试拨号直到服务端开始监听。
+func dialQUICgo(ctx context.Context, t *testing.T, addr string) *quic.Conn {
+	t.Helper()
+	tlsCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{quick.DefaultALPN}}
+	for {
+		dctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		conn, err := quic.DialAddr(dctx, addr, tlsCfg, &quic.Config{EnableDatagrams: true})
+		cancel()
+		if err == nil {
+			return conn
+		}
+		if ctx.Err() != nil {
+			t.Fatal(err)
+		}
+	}
+}
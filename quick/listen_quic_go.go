@@ -0,0 +1,253 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/qlog"
+	"github.com/quic-go/quic-go/qlogwriter"
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+)
+
+func listenQUICgo(parent context.Context, addr string, opt option, gate gateFunc, route routeFunc) (listener, error) {
+	cfg, err := opt.quicgoConfig()
+	if err != nil {
+		return nil, err
+	}
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	local := udp.LocalAddr()
+
+	tr := &quic.Transport{
+		Conn: udp,
+		ConnContext: func(ctx context.Context, info *quic.ClientInfo) (context.Context, error) {
+			hs := &quicgoHandshake{remote: info.RemoteAddr}
+			return context.WithValue(ctx, quicgoHandshakeKey{}, hs), nil
+		},
+	}
+	cfg.GetConfigForClient = func(info *quic.ClientInfo) (*quic.Config, error) {
+		if err := gate(info.RemoteAddr); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
+	if obs := opt.observer; obs != nil {
+		tr.Tracer = &quicgoVersionRecorder{obs: obs, local: local}
+		cfg.Tracer = func(ctx context.Context, _ bool, _ quic.ConnectionID) qlogwriter.Trace {
+			hs, _ := ctx.Value(quicgoHandshakeKey{}).(*quicgoHandshake)
+			if hs == nil {
+				return nil
+			}
+			return &quicgoHandshakeTrace{hs: hs, obs: obs, local: local}
+		}
+	}
+
+	var lis quicgoAccepter
+	if cfg.Allow0RTT {
+		var early *quic.EarlyListener
+		if early, err = tr.ListenEarly(opt.tlsConfig, cfg); err == nil {
+			lis = newQUICgoEarly(parent, early)
+		}
+	} else {
+		lis, err = tr.Listen(opt.tlsConfig, cfg)
+	}
+	if err != nil {
+		_ = udp.Close()
+		return nil, err
+	}
+
+	return &quicgoListener{parent: parent, udp: udp, tr: tr, lis: lis, route: route}, nil
+}
+
+type quicgoListener struct {
+	parent context.Context
+	udp    net.PacketConn
+	tr     *quic.Transport
+	lis    quicgoAccepter
+	route  routeFunc
+}
+
+// quicgoAccepter *quic.Listener 或 *quicgoEarly。
+type quicgoAccepter interface {
+	Accept(ctx context.Context) (*quic.Conn, error)
+	Close() error
+}
+
+func (l *quicgoListener) accept(ctx context.Context) (session, error) {
+	for {
+		conn, err := l.lis.Accept(ctx)
+		if err != nil {
+			return session{}, err
+		}
+		if hs, _ := conn.Context().Value(quicgoHandshakeKey{}).(*quicgoHandshake); hs != nil {
+			hs.established.Store(true)
+		}
+		state := conn.ConnectionState().TLS
+		proto := state.NegotiatedProtocol
+		if err = l.route("quic", proto, conn.LocalAddr(), conn.RemoteAddr()); err != nil {
+			_ = conn.CloseWithError(CloseNoRoute, err.Error())
+			continue
+		}
+
+		return session{mux: muxconn.NewQUICgo(l.parent, conn), proto: proto, state: state}, nil
+	}
+}
+
+func (l *quicgoListener) addr() net.Addr { return l.udp.LocalAddr() }
+
+func (l *quicgoListener) stop() error { return l.lis.Close() }
+
+func (l *quicgoListener) close() error {
+	err := l.tr.Close()
+	return errors.Join(err, l.udp.Close())
+}
+
+// maxEarlyPending 同时等待握手完成的 0-RTT 连接数上限。
+const maxEarlyPending = 256
+
+func newQUICgoEarly(ctx context.Context, lis *quic.EarlyListener) *quicgoEarly {
+	e := &quicgoEarly{
+		lis:     lis,
+		ready:   make(chan *quic.Conn),
+		pending: make(chan struct{}, maxEarlyPending),
+		done:    make(chan struct{}),
+	}
+	go e.serve(ctx)
+
+	return e
+}
+
+// quicgoEarly 接受 0-RTT 连接，为了防止重放，连接握手完成后才返回。
+// 每个连接在独立的协程中等待握手，不会阻塞其它连接；等待握手的连接达到上限时暂停 accept，
+// 由 quic-go 的 accept 队列拒绝多出的连接。
+type quicgoEarly struct {
+	lis     *quic.EarlyListener
+	ready   chan *quic.Conn
+	pending chan struct{} // 等待握手的连接，作为信号量使用
+	done    chan struct{}
+	err     error // 监听退出的原因，done 关闭后可读
+}
+
+func (e *quicgoEarly) Accept(ctx context.Context) (*quic.Conn, error) {
+	select {
+	case conn := <-e.ready:
+		return conn, nil
+	case <-e.done:
+		return nil, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (e *quicgoEarly) Close() error { return e.lis.Close() }
+
+func (e *quicgoEarly) serve(ctx context.Context) {
+	defer close(e.done)
+	for {
+		select {
+		case e.pending <- struct{}{}:
+		case <-ctx.Done():
+			e.err = ctx.Err()
+			return
+		}
+		conn, err := e.lis.Accept(ctx)
+		if err != nil {
+			e.err = err
+			return
+		}
+		go e.wait(conn)
+	}
+}
+
+func (e *quicgoEarly) wait(conn *quic.Conn) {
+	defer func() { <-e.pending }()
+
+	select {
+	case <-conn.HandshakeComplete():
+	case <-conn.Context().Done():
+		return
+	case <-e.done:
+		_ = conn.CloseWithError(0, ErrServerClosed.Error())
+		return
+	}
+
+	select {
+	case e.ready <- conn:
+	case <-e.done:
+		_ = conn.CloseWithError(0, ErrServerClosed.Error())
+	}
+}
+
+type quicgoHandshakeKey struct{}
+
+type quicgoHandshake struct {
+	remote      net.Addr
+	established atomic.Bool
+	reported    atomic.Bool
+}
+
+// quicgoHandshakeTrace 通过 qlog 事件感知握手阶段被关闭的连接。
+type quicgoHandshakeTrace struct {
+	hs    *quicgoHandshake
+	obs   Observer
+	local net.Addr
+}
+
+func (t *quicgoHandshakeTrace) AddProducer() qlogwriter.Recorder { return t }
+func (*quicgoHandshakeTrace) SupportsSchemas(string) bool        { return true }
+func (*quicgoHandshakeTrace) Close() error                       { return nil }
+
+func (t *quicgoHandshakeTrace) RecordEvent(evt qlogwriter.Event) {
+	closed, ok := evt.(qlog.ConnectionClosed)
+	if !ok || t.hs.established.Load() || !t.hs.reported.CompareAndSwap(false, true) {
+		return
+	}
+
+	herr := &HandshakeError{Network: "quic", Local: t.local, Remote: t.hs.remote, Err: closedError(closed)}
+	herr.Kind = handshakeKind(herr.Err)
+	t.obs.OnHandshakeError(herr)
+}
+
+// closedError 将 qlog 的连接关闭事件还原为 quic-go 的错误类型。
+func closedError(closed qlog.ConnectionClosed) error {
+	remote := closed.Initiator == qlog.InitiatorRemote
+	switch {
+	case closed.ConnectionError != nil:
+		return &quic.TransportError{Remote: remote, ErrorCode: *closed.ConnectionError, ErrorMessage: closed.Reason}
+	case closed.ApplicationError != nil:
+		return &quic.ApplicationError{Remote: remote, ErrorCode: *closed.ApplicationError, ErrorMessage: closed.Reason}
+	case closed.Trigger == qlog.ConnectionCloseTriggerVersionMismatch:
+		return &quic.VersionNegotiationError{}
+	case closed.Trigger == qlog.ConnectionCloseTriggerIdleTimeout:
+		return &quic.HandshakeTimeoutError{}
+	case closed.Trigger == qlog.ConnectionCloseTriggerStatelessReset:
+		return &quic.StatelessResetError{}
+	}
+
+	return errors.New("connection closed: " + string(closed.Trigger) + " " + closed.Reason)
+}
+
+// quicgoVersionRecorder 感知建立连接之前的版本协商失败。
+type quicgoVersionRecorder struct {
+	obs   Observer
+	local net.Addr
+}
+
+func (r *quicgoVersionRecorder) RecordEvent(evt qlogwriter.Event) {
+	if _, ok := evt.(qlog.VersionNegotiationSent); !ok {
+		return
+	}
+	r.obs.OnHandshakeError(&HandshakeError{
+		Kind:    HandshakeVersion,
+		Network: "quic",
+		Local:   r.local,
+		Err:     &quic.VersionNegotiationError{},
+	})
+}
+
+func (*quicgoVersionRecorder) Close() error { return nil }
@@ -0,0 +1,160 @@
+package quick
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+	"golang.org/x/net/quic"
+)
+
+func listenQUICx(parent context.Context, addr string, opt option, gate gateFunc, route routeFunc) (listener, error) {
+	cfg := opt.quicxConfig()
+	var hso *quicxHandshakeObserver
+	if obs := opt.observer; obs != nil {
+		hso = &quicxHandshakeObserver{obs: obs}
+		cfg.TLSConfig = hso.wrap(opt.tlsConfig)
+	}
+	endpoint, err := quic.Listen("udp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if hso != nil {
+		hso.local.Store(net.UDPAddrFromAddrPort(endpoint.LocalAddr()))
+	}
+	stopCtx, stopCancel := context.WithCancel(context.Background())
+
+	return &quicxListener{
+		parent:     parent,
+		endpoint:   endpoint,
+		gate:       gate,
+		route:      route,
+		stopCtx:    stopCtx,
+		stopCancel: stopCancel,
+	}, nil
+}
+
+type quicxListener struct {
+	parent     context.Context
+	endpoint   *quic.Endpoint
+	gate       gateFunc
+	route      routeFunc
+	once       sync.Once
+	stopCtx    context.Context // 停止监听后取消
+	stopCancel context.CancelFunc
+}
+
+// accept golang.org/x/net/quic 没有握手前的回调，只能在握手完成后检查准入。
+func (l *quicxListener) accept(ctx context.Context) (session, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(l.stopCtx, cancel)
+	defer stop()
+
+	for {
+		conn, err := l.endpoint.Accept(ctx)
+		if err != nil {
+			if l.stopCtx.Err() != nil {
+				return session{}, net.ErrClosed
+			}
+			return session{}, err
+		}
+		remote := net.UDPAddrFromAddrPort(conn.RemoteAddr())
+		if err = l.gate(remote); err != nil {
+			conn.Abort(err)
+			continue
+		}
+		state := conn.ConnectionState()
+		proto := state.NegotiatedProtocol
+		local := net.UDPAddrFromAddrPort(conn.LocalAddr())
+		if err = l.route("quic", proto, local, remote); err != nil {
+			conn.Abort(&quic.ApplicationError{Code: CloseNoRoute, Reason: err.Error()})
+			continue
+		}
+
+		return session{mux: muxconn.NewQUICx(l.parent, nil, conn), proto: proto, state: state}, nil
+	}
+}
+
+func (l *quicxListener) addr() net.Addr {
+	return net.UDPAddrFromAddrPort(l.endpoint.LocalAddr())
+}
+
+// stop golang.org/x/net/quic 关闭 Endpoint 会同时关闭所有连接，
+// 所以停止监听时只是不再 Accept，新握手的连接会被拒绝。
+func (l *quicxListener) stop() error {
+	l.once.Do(func() {
+		l.stopCancel()
+		go l.refuse()
+	})
+
+	return nil
+}
+
+func (l *quicxListener) refuse() {
+	for {
+		conn, err := l.endpoint.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		conn.Abort(ErrServerClosed)
+	}
+}
+
+func (l *quicxListener) close() error {
+	_ = l.stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	return l.endpoint.Close(ctx)
+}
+
+// quicxHandshakeObserver 通过 TLS 配置的回调感知握手失败。
+//
+// golang.org/x/net/quic 没有握手失败的回调，这里只能感知 ALPN 协商和客户端证书校验的失败，
+// 并且拿不到对端地址；握手超时等传输层的失败无法感知。
+type quicxHandshakeObserver struct {
+	obs   Observer
+	local atomic.Pointer[net.UDPAddr]
+}
+
+func (h *quicxHandshakeObserver) wrap(cfg *tls.Config) *tls.Config {
+	cfg = cfg.Clone()
+
+	// 只观察不拒绝，由 crypto/tls 发送 no_application_protocol 告警。
+	next := cfg.GetConfigForClient
+	protos := cfg.NextProtos
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if err := matchALPN(protos, hello.SupportedProtos); err != nil {
+			h.report(err)
+		}
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+	if verify := cfg.VerifyConnection; verify != nil {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			err := verify(cs)
+			if err != nil {
+				h.report(err)
+			}
+			return err
+		}
+	}
+
+	return cfg
+}
+
+func (h *quicxHandshakeObserver) report(err error) {
+	herr := &HandshakeError{Kind: handshakeKind(err), Network: "quic", Err: err}
+	if local := h.local.Load(); local != nil {
+		herr.Local = local
+	}
+	h.obs.OnHandshakeError(herr)
+}
@@ -0,0 +1,286 @@
+package quick
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+	"github.com/xmx/aegis-control/tlscert"
+)
+
+// DefaultTunnelPath TCP 通道 websocket 升级的默认路径，与 muxconn 客户端一致。
+const DefaultTunnelPath = "/api/tunnel"
+
+// listenTCP 监听 TCP+TLS，兼容 muxconn 客户端的 smux 协议：
+// 客户端通过 wss://addr/api/tunnel?protocol=smux 建立 websocket，
+// 升级后的底层连接交由 smux 多路复用。应用层协议由 alpn 参数指定，默认为 NextProtos 的第一个协议。
+//
+// muxconn.NewYaMUX 未保存 parent context，创建流时会 panic，所以暂不接受 yamux，
+// 客户端默认的协议顺序中 smux 优先于 yamux。
+func listenTCP(parent context.Context, addr string, opt option, gate gateFunc, route routeFunc) (listener, error) {
+	raw, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var defaultProto string
+	if protos := opt.tlsConfig.NextProtos; len(protos) != 0 {
+		defaultProto = protos[0]
+	}
+	// websocket 握手走 HTTP/1.1，不能沿用 QUIC 的 ALPN。
+	tlsCfg := opt.tlsConfig.Clone()
+	tlsCfg.NextProtos = []string{"http/1.1"}
+	if ch := opt.acme; ch != nil {
+		acmeTLS(tlsCfg, ch)
+	}
+	checkALPN(tlsCfg)
+
+	obs := opt.observer
+	if obs == nil {
+		obs = nopObserver{}
+	}
+	hl := &handshakeListener{
+		Listener: raw,
+		gate:     gate,
+		config:   tlsCfg,
+		obs:      obs,
+		ready:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go hl.serve()
+
+	tl := &tcpListener{
+		parent: parent,
+		hl:     hl,
+		route:  route,
+		proto:  defaultProto,
+		muxes:  make(chan session),
+		upgrader: &websocket.Upgrader{
+			HandshakeTimeout: 10 * time.Second,
+			CheckOrigin:      func(*http.Request) bool { return true },
+		},
+	}
+	mux := http.NewServeMux()
+	mux.Handle(opt.tcpPath, tl)
+	if ch := opt.acme; ch != nil {
+		mux.Handle("/.well-known/acme-challenge/", ch.HTTPHandler(nil))
+	}
+	tl.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return parent },
+	}
+	go func() { _ = tl.srv.Serve(hl) }()
+
+	return tl, nil
+}
+
+type tcpListener struct {
+	parent   context.Context
+	hl       *handshakeListener
+	srv      *http.Server
+	upgrader *websocket.Upgrader
+	route    routeFunc
+	proto    string // 未指定 alpn 参数时的应用层协议
+	muxes    chan session
+}
+
+func (l *tcpListener) accept(ctx context.Context) (session, error) {
+	select {
+	case <-ctx.Done():
+		return session{}, ctx.Err()
+	case <-l.hl.done:
+		return session{}, l.hl.closeErr()
+	case sess := <-l.muxes:
+		return sess, nil
+	}
+}
+
+func (l *tcpListener) addr() net.Addr { return l.hl.Addr() }
+
+// stop 关闭 TCP 监听，已经升级的通道不受影响。
+func (l *tcpListener) stop() error { return l.hl.Close() }
+
+func (l *tcpListener) close() error {
+	_ = l.hl.Close()
+	return l.srv.Close()
+}
+
+func (l *tcpListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if proto := query.Get("protocol"); proto != "smux" {
+		http.Error(w, "unsupported protocol: "+proto, http.StatusBadRequest)
+		return
+	}
+	proto := query.Get("alpn")
+	if proto == "" {
+		proto = l.proto
+	}
+	remote, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err := l.route("tcp", proto, l.addr(), remote); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	ws, err := l.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	conn := ws.NetConn()
+	mux, err := muxconn.NewSMUX(l.parent, conn, nil, true)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	sess := session{mux: mux, proto: proto}
+	if r.TLS != nil {
+		sess.state = *r.TLS
+	}
+	select {
+	case l.muxes <- sess:
+	case <-l.hl.done:
+		_ = mux.Close()
+	case <-l.parent.Done():
+		_ = mux.Close()
+	}
+}
+
+// handshakeListener 在 TLS 握手之前检查准入，并在独立的协程中完成 TLS 握手，
+// 握手失败的连接交由 Observer 观察，不会阻塞其它连接的 accept。
+type handshakeListener struct {
+	net.Listener
+	gate   gateFunc
+	config *tls.Config
+	obs    Observer
+	ready  chan net.Conn
+	once   sync.Once
+	done   chan struct{}
+	err    error // 监听退出的原因，done 关闭后可读
+}
+
+func (hl *handshakeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-hl.ready:
+		return conn, nil
+	case <-hl.done:
+		return nil, hl.closeErr()
+	}
+}
+
+func (hl *handshakeListener) Close() error {
+	err := hl.Listener.Close()
+	hl.shutdown(nil)
+
+	return err
+}
+
+func (hl *handshakeListener) closeErr() error {
+	if hl.err != nil {
+		return hl.err
+	}
+
+	return net.ErrClosed
+}
+
+func (hl *handshakeListener) shutdown(err error) {
+	hl.once.Do(func() {
+		hl.err = err
+		close(hl.done)
+	})
+}
+
+func (hl *handshakeListener) serve() {
+	var tempDelay time.Duration // how long to sleep on accept failure
+	for {
+		conn, err := hl.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				hl.obs.OnAcceptError(hl.Addr(), err, true)
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				tempDelay = min(tempDelay, time.Second)
+				time.Sleep(tempDelay)
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				err = nil
+			}
+			hl.shutdown(err)
+			return
+		}
+		tempDelay = 0
+
+		if err = hl.gate(conn.RemoteAddr()); err != nil {
+			_ = conn.Close()
+			continue
+		}
+		go hl.handshake(conn)
+	}
+}
+
+func (hl *handshakeListener) handshake(conn net.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tc := tls.Server(conn, hl.config)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		hl.obs.OnHandshakeError(&HandshakeError{
+			Kind:    handshakeKind(err),
+			Network: "tcp",
+			Local:   conn.LocalAddr(),
+			Remote:  conn.RemoteAddr(),
+			Err:     err,
+		})
+		return
+	}
+	// TLS-ALPN-01 验证只需要完成握手。
+	if tc.ConnectionState().NegotiatedProtocol == tlscert.ACMEALPN {
+		_ = tc.Close()
+		return
+	}
+
+	select {
+	case hl.ready <- tc:
+	case <-hl.done:
+		_ = tc.Close()
+	}
+}
+
+// acmeTLS 让 TLS-ALPN-01 验证请求使用验证证书完成握手，验证请求不携带客户端证书，
+// 所以不受 mTLS 配置的影响。
+func acmeTLS(cfg *tls.Config, ch tlscert.ACMEChallenger) {
+	cfg.NextProtos = append(cfg.NextProtos, tlscert.ACMEALPN)
+	next := cfg.GetConfigForClient
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if !slices.Contains(hello.SupportedProtos, tlscert.ACMEALPN) {
+			if next != nil {
+				return next(hello)
+			}
+			return nil, nil
+		}
+
+		crt, err := ch.GetChallengeCertificate(hello)
+		if err != nil || crt == nil {
+			return nil, err
+		}
+
+		return &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{*crt},
+			NextProtos:   []string{tlscert.ACMEALPN},
+		}, nil
+	}
+}
@@ -0,0 +1,75 @@
+package quick_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/xmx/aegis-control/quick"
+	"github.com/xmx/aegis-control/quick/quicktest"
+)
+
+// TestTCPLoopback TLS+websocket+smux 客户端经由 TCP 回落通道接入，并由处理器正常处理。
+func TestTCPLoopback(t *testing.T) {
+	srv, err := quicktest.NewServer(echoAccepter{}, quick.WithTCPFallback(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer srv.Close()
+	addr := localAddr(t, srv.Quick(), true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	mux := dialTCP(ctx, t, addr, "")
+	if name, _ := mux.Library(); name != "smux" {
+		t.Fatalf("client library = %s, want smux", name)
+	}
+	if _, ok := mux.RemoteAddr().(*net.TCPAddr); !ok {
+		t.Fatalf("client remote address %v is not TCP", mux.RemoteAddr())
+	}
+
+	for i := range 3 {
+		stream, err := mux.Open(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := bytes.Repeat([]byte{byte('a' + i)}, 64<<10)
+		go func() { _, _ = stream.Write(want) }()
+		got := make([]byte, len(want))
+		if _, err = io.ReadFull(stream, got); err != nil {
+			t.Fatalf("stream %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("stream %d: echo mismatch", i)
+		}
+		_ = stream.Close()
+	}
+	if n := srv.Quick().NumConns(); n != 1 {
+		t.Fatalf("NumConns() = %d, want 1", n)
+	}
+
+	// 客户端关闭后服务端结束处理。
+	_ = mux.Close()
+	waitFor(t, "connection released", func() bool { return srv.Quick().NumConns() == 0 })
+
+	// 只接受 smux 协议。
+	query := url.Values{"protocol": {"yamux"}}
+	reqURL := &url.URL{Scheme: "wss", Host: addr, Path: quick.DefaultTunnelPath, RawQuery: query.Encode()}
+	d := &websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	ws, res, err := d.DialContext(ctx, reqURL.String(), nil)
+	if err == nil {
+		_ = ws.Close()
+		t.Fatal("yamux upgrade accepted")
+	}
+	if res == nil || res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("yamux upgrade response = %v, want 400", res)
+	}
+}
@@ -0,0 +1,73 @@
+package quick
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+)
+
+// listener 屏蔽不同实现库的监听差异。
+type listener interface {
+	// accept 接受一个已经完成握手的连接。
+	accept(ctx context.Context) (session, error)
+
+	addr() net.Addr
+
+	// stop 停止接受新连接，已经建立的连接不受影响。
+	stop() error
+
+	// close 释放监听的所有资源，已经建立的连接可能会被一并关闭。
+	close() error
+}
+
+// session 已经完成握手的连接。
+type session struct {
+	mux   muxconn.Muxer
+	proto string              // 协商的应用层协议
+	state tls.ConnectionState // TLS 连接状态，mTLS 时含有客户端证书
+}
+
+// gateFunc 握手前的准入检查，返回错误时拒绝该连接。
+type gateFunc func(remote net.Addr) error
+
+// listen 在 addr 上监听 QUIC，开启了 TCP 时同时监听 TCP。
+func (s *Server) listen(ctx context.Context, addr string) ([]listener, error) {
+	lis, err := s.listenQUIC(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.opt.tcpPath == "" {
+		return []listener{lis}, nil
+	}
+
+	tcp, err := listenTCP(ctx, addr, s.opt, s.gate, s.route)
+	if err != nil {
+		_ = lis.close()
+		return nil, err
+	}
+
+	return []listener{lis, tcp}, nil
+}
+
+func (s *Server) listenQUIC(ctx context.Context, addr string) (listener, error) {
+	switch s.opt.backend {
+	case BackendQUICx:
+		return listenQUICx(ctx, addr, s.opt, s.gate, s.route)
+	default:
+		return listenQUICgo(ctx, addr, s.opt, s.gate, s.route)
+	}
+}
+
+func (s *Server) gate(remote net.Addr) error {
+	if s.adm == nil {
+		return nil
+	}
+	err := s.adm.early(remote)
+	if err != nil {
+		s.observer().OnHandshakeError(&HandshakeError{Kind: HandshakeRejected, Network: remote.Network(), Remote: remote, Err: err})
+	}
+
+	return err
+}
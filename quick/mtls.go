@@ -0,0 +1,165 @@
+package quick
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+	"github.com/xmx/aegis-control/tlscert"
+)
+
+// ClientAuthConfig mTLS 客户端认证配置，客户端证书由内部 CA 签发，
+// 证书中的身份格式见 tlscert.Identity。
+type ClientAuthConfig struct {
+	// ClientCAs 返回签发客户端证书的 CA，例如 tlscert.CA.CertPool，每次握手时调用，CA 轮换后立即生效。
+	ClientCAs func() *x509.CertPool
+
+	// Optional 为 true 时允许不携带证书的客户端连接，由处理器继续使用密钥等方式认证；
+	// 携带了证书的客户端仍然需要通过校验。
+	Optional bool
+
+	// Revoked 检查证书是否已被吊销，返回 true 时拒绝握手，为 nil 时不检查。
+	//
+	// tlscert.CA 只吊销根证书：紧急轮换后 CertPool 不再包含旧的根证书，由其签发的证书自然无法通过校验；
+	// CA 不维护单个证书的吊销列表，需要吊销单个节点的证书时由调用方提供数据来源，
+	// 例如按照证书序列号查询数据库中的吊销记录。
+	Revoked func(cert *x509.Certificate) bool
+}
+
+// ClientAuthError 客户端证书未通过校验。
+type ClientAuthError struct {
+	Reason string
+	Err    error
+}
+
+func (e *ClientAuthError) Error() string {
+	msg := "quick: client certificate rejected: " + e.Reason
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+func (e *ClientAuthError) Unwrap() error { return e.Err }
+
+// ClientIdentity 通过 mTLS 认证的客户端身份。
+type ClientIdentity struct {
+	tlscert.Identity
+	Certificate *x509.Certificate
+}
+
+// IdentityFromContext 获取连接上下文中的客户端身份，见 MUXContext。
+func IdentityFromContext(ctx context.Context) (*ClientIdentity, bool) {
+	ident, ok := ctx.Value(identityKey{}).(*ClientIdentity)
+	return ident, ok
+}
+
+// MUXContext 获取处理器收到的连接的上下文，连接处理结束后取消。
+// 开启 mTLS 且客户端携带了证书时，可以通过 IdentityFromContext 获取客户端身份。
+// mux 不是由 Server 交给处理器的连接时返回 context.Background()。
+func MUXContext(mux muxconn.Muxer) context.Context {
+	if cm, ok := mux.(*ctxMuxer); ok {
+		return cm.ctx
+	}
+
+	return context.Background()
+}
+
+// RequireBroker 要求请求上下文中带有 broker 角色的客户端身份，可以作为 linkhub.NewForwardHandler 的 authorize。
+//
+// 挂载服务的 http.Server 需要通过 ConnContext 等方式将 MUXContext 作为请求上下文，
+// 身份才能传递到请求中。
+func RequireBroker(r *http.Request) error {
+	ident, ok := IdentityFromContext(r.Context())
+	if !ok {
+		return errors.New("unauthenticated caller")
+	}
+	if ident.Role != tlscert.RoleBroker {
+		return errors.New("caller " + ident.String() + " is not a broker")
+	}
+
+	return nil
+}
+
+type identityKey struct{}
+
+// ctxMuxer 携带连接上下文的 Muxer。
+type ctxMuxer struct {
+	muxconn.Muxer
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// withContext 为连接绑定上下文，客户端证书已经在握手时校验过，这里只解析身份。
+func withContext(parent context.Context, sess session) *ctxMuxer {
+	ctx := parent
+	if certs := sess.state.PeerCertificates; len(certs) != 0 {
+		if ident, err := tlscert.ParseIdentity(certs[0]); err == nil {
+			ctx = context.WithValue(ctx, identityKey{}, &ClientIdentity{Identity: ident, Certificate: certs[0]})
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &ctxMuxer{Muxer: sess.mux, ctx: ctx, cancel: cancel}
+}
+
+// apply 将 mTLS 配置应用到服务端 TLS 配置。
+func (c *ClientAuthConfig) apply(cfg *tls.Config) {
+	cfg.ClientAuth = tls.RequireAnyClientCert
+	if c.Optional {
+		cfg.ClientAuth = tls.RequestClientCert
+	}
+
+	verify := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(cs); err != nil {
+				return err
+			}
+		}
+		return c.verify(cs)
+	}
+}
+
+func (c *ClientAuthConfig) verify(cs tls.ConnectionState) error {
+	certs := cs.PeerCertificates
+	if len(certs) == 0 {
+		if c.Optional {
+			return nil
+		}
+		return &ClientAuthError{Reason: "certificate required"}
+	}
+
+	leaf := certs[0]
+	var roots *x509.CertPool
+	if c.ClientCAs != nil {
+		roots = c.ClientCAs()
+	}
+	if roots == nil {
+		return &ClientAuthError{Reason: "no client CA configured"}
+	}
+	inters := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		inters.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inters,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		return &ClientAuthError{Reason: "untrusted certificate", Err: err}
+	}
+	if _, err := tlscert.ParseIdentity(leaf); err != nil {
+		return &ClientAuthError{Reason: "invalid identity", Err: err}
+	}
+	if c.Revoked != nil && c.Revoked(leaf) {
+		return &ClientAuthError{Reason: "certificate revoked"}
+	}
+
+	return nil
+}
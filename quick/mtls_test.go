@@ -0,0 +1,163 @@
+package quick_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+	"github.com/xmx/aegis-control/quick"
+	"github.com/xmx/aegis-control/quick/quicktest"
+	"github.com/xmx/aegis-control/tlscert"
+)
+
+// testCA 测试用的客户端证书 CA。
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		IsCA:                  true,
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "quick test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue 签发携带 ident 身份的客户端证书。
+func (ca *testCA) issue(t *testing.T, serial int64, ident tlscert.Identity) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: ident.ID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{ident.URI()},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// identityResult 处理器中观察到的客户端身份。
+type identityResult struct {
+	ident  *quick.ClientIdentity
+	broker error // quick.RequireBroker 对携带连接上下文的请求的结果
+}
+
+// identityAccepter 在处理器中读取客户端身份后关闭连接。
+type identityAccepter chan identityResult
+
+func (h identityAccepter) AcceptMUX(mux muxconn.Muxer) {
+	ctx := quick.MUXContext(mux)
+	ident, _ := quick.IdentityFromContext(ctx)
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/api/forward", nil)
+	h <- identityResult{ident: ident, broker: quick.RequireBroker(req)}
+}
+
+func TestClientAuthIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	broker := tlscert.Identity{Role: tlscert.RoleBroker, ID: "b1"}
+	agent := tlscert.Identity{Role: tlscert.RoleAgent, ID: "a1"}
+	const revokedSerial = 99
+
+	for _, backend := range backends {
+		t.Run(string(backend), func(t *testing.T) {
+			results := make(identityAccepter, 4)
+			obs := make(chanObserver, 8)
+			srv, err := quicktest.NewServer(results, quick.WithBackend(backend), quick.WithObserver(obs),
+				quick.WithClientAuth(quick.ClientAuthConfig{
+					ClientCAs: func() *x509.CertPool { return ca.pool },
+					Revoked:   func(cert *x509.Certificate) bool { return cert.SerialNumber.Int64() == revokedSerial },
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			//goland:noinspection GoUnhandledErrorResult
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			for i, ident := range []tlscert.Identity{broker, agent} {
+				cert := ca.issue(t, int64(i+2), ident)
+				mux, err := srv.Dial(ctx, quicktest.WithClientCertificate(cert))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var res identityResult
+				select {
+				case res = <-results:
+				case <-ctx.Done():
+					t.Fatal("handler not called")
+				}
+				_ = mux.Close()
+
+				if res.ident == nil || res.ident.Identity != ident {
+					t.Fatalf("IdentityFromContext() = %v, want %v", res.ident, ident)
+				}
+				if !res.ident.Certificate.Equal(cert.Leaf) {
+					t.Fatal("identity carries a different certificate")
+				}
+				if isBroker := res.broker == nil; isBroker != (ident.Role == tlscert.RoleBroker) {
+					t.Fatalf("RequireBroker() = %v for %s", res.broker, ident)
+				}
+			}
+
+			// Revoked 返回 true 的证书在握手阶段被拒绝，不会交给处理器。
+			revoked := ca.issue(t, revokedSerial, broker)
+			if mux, err := srv.Dial(ctx, quicktest.WithClientCertificate(revoked)); err == nil {
+				defer mux.Close()
+			}
+			if herr := obs.wait(t); herr.Kind != quick.HandshakeTLS {
+				t.Fatalf("handshake error kind = %s, want %s", herr.Kind, quick.HandshakeTLS)
+			}
+			select {
+			case res := <-results:
+				t.Fatalf("handler called for revoked certificate: %v", res.ident)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
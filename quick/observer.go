@@ -0,0 +1,166 @@
+package quick
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+
+	"github.com/quic-go/quic-go"
+)
+
+// 握手失败的分类。
+const (
+	HandshakeTLS      = "tls"      // TLS 握手失败，例如证书校验不通过、收到告警
+	HandshakeALPN     = "alpn"     // 应用层协议协商失败
+	HandshakeVersion  = "version"  // QUIC 版本协商失败
+	HandshakeTimeout  = "timeout"  // 握手超时
+	HandshakeRejected = "rejected" // 被准入控制拒绝
+	HandshakeOther    = "other"    // 其它原因
+)
+
+// HandshakeError 连接在握手阶段失败。
+type HandshakeError struct {
+	Kind    string   // 失败分类，见 HandshakeTLS 等常量
+	Network string   // quic 或 tcp
+	Local   net.Addr // 监听地址，可能为 nil
+	Remote  net.Addr // 对端地址，可能为 nil
+	Err     error
+}
+
+func (e *HandshakeError) Error() string {
+	remote := "<nil>"
+	if e.Remote != nil {
+		remote = e.Remote.String()
+	}
+	msg := e.Network + " handshake with " + remote + " failed (" + e.Kind + ")"
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+func (e *HandshakeError) Unwrap() error { return e.Err }
+
+// Observer 观察服务的 accept 和握手过程，方法会被并发调用，不应阻塞。
+type Observer interface {
+	// OnAcceptError 监听 accept 出错，temporary 为 true 时会退避后重试，否则该监听退出。
+	OnAcceptError(local net.Addr, err error, temporary bool)
+
+	// OnHandshakeError 连接握手失败或被拒绝。
+	OnHandshakeError(err *HandshakeError)
+}
+
+// NewLogObserver 将 accept 和握手错误输出到日志。
+func NewLogObserver(log *slog.Logger) Observer {
+	return &logObserver{log: log}
+}
+
+type logObserver struct {
+	log *slog.Logger
+}
+
+func (lo *logObserver) OnAcceptError(local net.Addr, err error, temporary bool) {
+	attrs := []any{"local", addrString(local), "error", err, "temporary", temporary}
+	if temporary {
+		lo.log.Warn("监听 accept 出错，稍后重试", attrs...)
+	} else {
+		lo.log.Error("监听 accept 出错，监听退出", attrs...)
+	}
+}
+
+func (lo *logObserver) OnHandshakeError(err *HandshakeError) {
+	lo.log.Info("连接握手失败", "kind", err.Kind, "network", err.Network,
+		"local", addrString(err.Local), "remote", addrString(err.Remote), "error", err.Err)
+}
+
+type nopObserver struct{}
+
+func (nopObserver) OnAcceptError(net.Addr, error, bool) {}
+func (nopObserver) OnHandshakeError(*HandshakeError)    {}
+
+// handshakeKind 按照错误类型对握手错误分类。
+func handshakeKind(err error) string {
+	var (
+		re    *RouteError
+		cae   *ClientAuthError
+		te    *quic.TransportError
+		vne   *quic.VersionNegotiationError
+		hte   *quic.HandshakeTimeoutError
+		ite   *quic.IdleTimeoutError
+		alert tls.AlertError
+		cve   *tls.CertificateVerificationError
+		rhe   tls.RecordHeaderError
+		ne    net.Error
+	)
+	switch {
+	case errors.As(err, &re):
+		return HandshakeALPN
+	case errors.As(err, &cae), errors.As(err, &cve), errors.As(err, &rhe):
+		return HandshakeTLS
+	case errors.As(err, &te):
+		if !te.ErrorCode.IsCryptoError() {
+			return HandshakeOther
+		}
+		alert = tls.AlertError(te.ErrorCode - 0x100)
+		if alert == alertNoApplicationProtocol {
+			return HandshakeALPN
+		}
+		return HandshakeTLS
+	case errors.As(err, &alert):
+		if alert == alertNoApplicationProtocol {
+			return HandshakeALPN
+		}
+		return HandshakeTLS
+	case errors.As(err, &vne):
+		return HandshakeVersion
+	case errors.As(err, &hte), errors.As(err, &ite):
+		return HandshakeTimeout
+	case errors.As(err, &ne) && ne.Timeout():
+		return HandshakeTimeout
+	}
+
+	return HandshakeOther
+}
+
+// checkALPN 在 crypto/tls 协商应用层协议之前检查客户端提供的协议，
+// 与 cfg.NextProtos 没有交集时返回 *RouteError，避免 crypto/tls 返回无法分类的普通错误。
+func checkALPN(cfg *tls.Config) {
+	next := cfg.GetConfigForClient
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if err := matchALPN(cfg.NextProtos, hello.SupportedProtos); err != nil {
+			return nil, err
+		}
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+}
+
+// matchALPN 客户端提供了协议但是都不在 protos 中时返回 *RouteError。
+func matchALPN(protos, offered []string) error {
+	if len(offered) == 0 {
+		return nil
+	}
+	for _, proto := range offered {
+		if slices.Contains(protos, proto) {
+			return nil
+		}
+	}
+
+	return &RouteError{Proto: offered[0]}
+}
+
+// alertNoApplicationProtocol TLS no_application_protocol 告警码。
+const alertNoApplicationProtocol tls.AlertError = 120
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	return addr.String()
+}
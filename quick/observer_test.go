@@ -0,0 +1,196 @@
+package quick_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xmx/aegis-control/quick"
+	"github.com/xmx/aegis-control/quick/quicktest"
+)
+
+// chanObserver 将握手错误投递到通道。
+type chanObserver chan *quick.HandshakeError
+
+func (chanObserver) OnAcceptError(net.Addr, error, bool) {}
+
+func (c chanObserver) OnHandshakeError(err *quick.HandshakeError) {
+	select {
+	case c <- err:
+	default:
+	}
+}
+
+func (c chanObserver) wait(t *testing.T) *quick.HandshakeError {
+	t.Helper()
+	select {
+	case err := <-c:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("no handshake error observed")
+		return nil
+	}
+}
+
+func selfSigned(t *testing.T) tls.Certificate {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+}
+
+func TestBadHandshake(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []quick.Option
+		dial []quicktest.DialOption
+		kind string
+	}{
+		{
+			name: "alpn",
+			dial: []quicktest.DialOption{quicktest.WithALPN("unknown")},
+			kind: quick.HandshakeALPN,
+		},
+		{
+			name: "client-cert",
+			opts: []quick.Option{quick.WithClientAuth(quick.ClientAuthConfig{
+				ClientCAs: func() *x509.CertPool { return x509.NewCertPool() },
+			})},
+			dial: []quicktest.DialOption{quicktest.WithClientCertificate(selfSigned(t))},
+			kind: quick.HandshakeTLS,
+		},
+	}
+	for _, backend := range backends {
+		for _, tc := range cases {
+			t.Run(string(backend)+"/"+tc.name, func(t *testing.T) {
+				obs := make(chanObserver, 8)
+				opts := append([]quick.Option{quick.WithBackend(backend), quick.WithObserver(obs)}, tc.opts...)
+				srv, err := quicktest.NewServer(echoAccepter{}, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer srv.Close()
+
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				// TLS 1.3 客户端可能先于服务端完成握手，所以不检查 Dial 的结果。
+				if mux, err := srv.Dial(ctx, tc.dial...); err == nil {
+					defer mux.Close()
+				}
+
+				herr := obs.wait(t)
+				if herr.Kind != tc.kind || herr.Network != "quic" {
+					t.Fatalf("handshake error = %v (kind %s), want kind %s", herr, herr.Kind, tc.kind)
+				}
+			})
+		}
+	}
+
+	t.Run("tcp/alpn", func(t *testing.T) {
+		obs := make(chanObserver, 8)
+		srv, err := quicktest.NewServer(echoAccepter{}, quick.WithTCPFallback(""), quick.WithObserver(obs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+
+		var addr string
+		for _, a := range srv.Quick().LocalAddrs() {
+			if _, ok := a.(*net.TCPAddr); ok {
+				addr = a.String()
+			}
+		}
+		cfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"unknown"}}
+		if conn, err := tls.Dial("tcp", addr, cfg); err == nil {
+			_ = conn.Close()
+			t.Fatal("tls handshake with unknown alpn succeeded")
+		}
+
+		herr := obs.wait(t)
+		var re *quick.RouteError
+		if herr.Kind != quick.HandshakeALPN || !errors.As(herr, &re) {
+			t.Fatalf("handshake error = %v (kind %s), want a *RouteError", herr, herr.Kind)
+		}
+	})
+}
+
+func TestServeStop(t *testing.T) {
+	cert := selfSigned(t)
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS13, Certificates: []tls.Certificate{cert}}
+	stops := []struct {
+		name string
+		stop func(srv *quick.Server, cancel context.CancelFunc)
+		want error
+	}{
+		{name: "close", stop: func(srv *quick.Server, _ context.CancelFunc) { _ = srv.Close() }, want: quick.ErrServerClosed},
+		{name: "cancel", stop: func(_ *quick.Server, cancel context.CancelFunc) { cancel() }, want: context.Canceled},
+	}
+	for _, backend := range backends {
+		for _, sc := range stops {
+			t.Run(string(backend)+"/"+sc.name, func(t *testing.T) {
+				srv := quick.New("127.0.0.1:0", quick.WithBackend(backend), quick.WithTLSConfig(tlsCfg),
+					quick.WithTCPFallback(""), quick.WithHandler(echoAccepter{}))
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				errc := make(chan error, 1)
+				go func() { errc <- srv.ListenAndServe(ctx) }()
+
+				deadline := time.Now().Add(5 * time.Second)
+				for len(srv.LocalAddrs()) != 2 {
+					if time.Now().After(deadline) {
+						t.Fatal("server not listening")
+					}
+					time.Sleep(5 * time.Millisecond)
+				}
+				addrs := srv.LocalAddrs()
+				sc.stop(srv, cancel)
+
+				select {
+				case err := <-errc:
+					if !errors.Is(err, sc.want) {
+						t.Fatalf("ListenAndServe() = %v, want %v", err, sc.want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("ListenAndServe did not return")
+				}
+				// 监听已经释放，地址可以重新监听。
+				for _, a := range addrs {
+					var err error
+					var c interface{ Close() error }
+					if _, ok := a.(*net.TCPAddr); ok {
+						c, err = net.Listen("tcp", a.String())
+					} else {
+						c, err = net.ListenPacket("udp", a.String())
+					}
+					if err != nil {
+						t.Fatalf("address %s still in use: %v", a, err)
+					}
+					_ = c.Close()
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package quick
+
+import (
+	"crypto/tls"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/xmx/aegis-common/muxlink/muxproto"
+	"github.com/xmx/aegis-control/tlscert"
+	xquic "golang.org/x/net/quic"
+)
+
+// Backend QUIC 协议的实现库。
+//
+// BackendQUICgo 支持客户端发起的连接迁移（RFC 9000 第 9 节），客户端切换网络后连接保持不变，
+// Muxer.RemoteAddr 返回迁移后的地址，linkhub.Huber.Migrate 据此记录地址变化；
+// BackendQUICx 暂不支持连接迁移和 0-RTT。
+type Backend string
+
+const (
+	BackendQUICgo Backend = "quic-go" // github.com/quic-go/quic-go
+	BackendQUICx  Backend = "quic"    // golang.org/x/net/quic
+)
+
+// DefaultALPN 未设置 tls.Config.NextProtos 时默认协商的应用层协议，与 muxconn 客户端一致。
+const DefaultALPN = "aegis"
+
+type Option func(*option)
+
+// WithBackend 选择 QUIC 的实现库，默认 BackendQUICgo。
+func WithBackend(b Backend) Option {
+	return func(o *option) {
+		o.backend = b
+	}
+}
+
+// WithTLSConfig 设置 TLS 配置，NextProtos 为空时默认为 DefaultALPN。
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(o *option) {
+		o.tlsConfig = cfg
+	}
+}
+
+// WithIdleTimeout 连接空闲超时时间，小于等于 0 时使用实现库的默认值。
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *option) {
+		o.idleTimeout = d
+	}
+}
+
+// WithMaxStreams 单个连接允许对端同时打开的最大双向流数量，小于等于 0 时使用实现库的默认值。
+func WithMaxStreams(n int64) Option {
+	return func(o *option) {
+		o.maxStreams = n
+	}
+}
+
+// WithQUICgoConfig 设置 BackendQUICgo 的传输配置，KeepAlivePeriod、流控窗口等字段原样生效，
+// WithIdleTimeout、WithMaxStreams 和 ResumptionConfig.Allow0RTT 设置了时覆盖对应的字段。
+// 准入控制需要接管 GetConfigForClient，所以 cfg 不能设置该字段；Tracer 不能与 WithObserver 同时使用。
+func WithQUICgoConfig(cfg *quic.Config) Option {
+	return func(o *option) {
+		o.quicgo = cfg
+	}
+}
+
+// WithQUICxConfig 设置 BackendQUICx 的传输配置，TLSConfig 字段被忽略，使用 WithTLSConfig 设置，
+// 其它字段原样生效，WithIdleTimeout 和 WithMaxStreams 设置了时覆盖对应的字段。
+func WithQUICxConfig(cfg *xquic.Config) Option {
+	return func(o *option) {
+		o.quicx = cfg
+	}
+}
+
+// WithHandler 设置默认的通道处理器，协商的应用层协议没有通过 WithRoute 注册处理器时交由 h 处理。
+func WithHandler(h muxproto.MUXAccepter) Option {
+	return func(o *option) {
+		o.handler = h
+	}
+}
+
+// WithRoute 按照 TLS 协商的应用层协议（ALPN）分发连接，协商结果为 proto 的连接交由 h 处理。
+// 注册的协议会自动加入 tls.Config.NextProtos，既没有路由也没有默认处理器的连接会被拒绝。
+//
+// TCP 通道的 TLS 固定协商 http/1.1 以完成 websocket 握手，无法通过 ALPN 选择路由，
+// 客户端需要在升级地址的 alpn 参数中指定协议，例如 wss://addr/api/tunnel?protocol=smux&alpn=proto，
+// 未指定时为 NextProtos 的第一个协议。两种传输方式注册的路由相同，只是协议的来源不同。
+func WithRoute(proto string, h muxproto.MUXAccepter) Option {
+	return func(o *option) {
+		if o.routes == nil {
+			o.routes = make(map[string]muxproto.MUXAccepter, 4)
+		}
+		o.routes[proto] = h
+	}
+}
+
+// WithTCPFallback 在同一地址额外监听 TCP+TLS，供 UDP 被阻断的网络使用。
+// 客户端通过 websocket 升级到 path 后使用 smux 多路复用，path 为空时默认 DefaultTunnelPath。
+func WithTCPFallback(path string) Option {
+	return func(o *option) {
+		if path == "" {
+			path = DefaultTunnelPath
+		}
+		o.tcpPath = path
+	}
+}
+
+// WithAdmission 开启连接准入控制。
+func WithAdmission(cfg AdmissionConfig) Option {
+	return func(o *option) {
+		o.admission = &cfg
+	}
+}
+
+// WithClientAuth 开启 mTLS 客户端认证，吊销或者无法识别身份的证书在握手阶段被拒绝，
+// 处理器通过 MUXContext 和 IdentityFromContext 获取客户端身份。
+func WithClientAuth(cfg ClientAuthConfig) Option {
+	return func(o *option) {
+		o.clientAuth = &cfg
+	}
+}
+
+// WithResumption 配置 TLS 会话恢复和 0-RTT。
+func WithResumption(cfg ResumptionConfig) Option {
+	return func(o *option) {
+		o.resumption = &cfg
+	}
+}
+
+// ResumptionConfig TLS 会话恢复配置，未配置时使用 crypto/tls 默认的会话票据。
+type ResumptionConfig struct {
+	// TicketKeys 会话票据密钥，多个节点使用相同的密钥时客户端可以跨节点恢复会话。
+	// 第一个密钥用于加密新票据，全部密钥都可以解密，轮换时把新密钥放在第一个。
+	// 为空时由 crypto/tls 自动生成并定期轮换。
+	TicketKeys [][32]byte
+
+	// Allow0RTT 允许客户端恢复会话时发送 0-RTT 数据，仅 BackendQUICgo 支持。
+	// 0-RTT 数据可能被重放，所以连接仍然在握手完成、确认对端存活后才交给处理器，
+	// 减少的是客户端首个请求的往返。
+	Allow0RTT bool
+}
+
+// WithACME 在 TCP 通道上应答 ACME 验证，见 tlscert.NewACMEIssuer：
+// 协商 acme-tls/1 的握手返回 TLS-ALPN-01 验证证书，握手完成后直接关闭连接；
+// /.well-known/acme-challenge/ 下的请求按照 HTTP-01 应答，供 80 端口重定向过来的验证使用，
+// 开启了必选的 mTLS 时 HTTP-01 无法通过 TCP 通道验证，应当在 80 端口直接使用 ACMEChallenger.HTTPHandler。
+// ACME 服务只通过 TCP 验证，所以需要同时开启 WithTCPFallback。
+func WithACME(ch tlscert.ACMEChallenger) Option {
+	return func(o *option) {
+		o.acme = ch
+	}
+}
+
+// WithObserver 设置 accept 和握手过程的观察者，例如 NewLogObserver。
+func WithObserver(obs Observer) Option {
+	return func(o *option) {
+		o.observer = obs
+	}
+}
+
+type option struct {
+	backend     Backend
+	tlsConfig   *tls.Config
+	idleTimeout time.Duration
+	maxStreams  int64
+	quicgo      *quic.Config
+	quicx       *xquic.Config
+	handler     muxproto.MUXAccepter
+	routes      map[string]muxproto.MUXAccepter // ALPN -> 处理器
+	tcpPath     string                          // 不为空时开启 TCP 监听
+	admission   *AdmissionConfig
+	clientAuth  *ClientAuthConfig
+	resumption  *ResumptionConfig
+	acme        tlscert.ACMEChallenger
+	observer    Observer
+}
+
+func newOption(opts []Option) option {
+	var opt option
+	for _, fn := range opts {
+		if fn != nil {
+			fn(&opt)
+		}
+	}
+	if opt.backend == "" {
+		opt.backend = BackendQUICgo
+	}
+	opt.tlsConfig = opt.serverTLS()
+
+	return opt
+}
+
+func (o option) serverTLS() *tls.Config {
+	cfg := o.tlsConfig
+	if cfg == nil {
+		cfg = &tls.Config{MinVersion: tls.VersionTLS13}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if len(cfg.NextProtos) == 0 && (len(o.routes) == 0 || o.handler != nil) {
+		cfg.NextProtos = []string{DefaultALPN}
+	}
+	protos := make([]string, 0, len(o.routes))
+	for proto := range o.routes {
+		if !slices.Contains(cfg.NextProtos, proto) {
+			protos = append(protos, proto)
+		}
+	}
+	slices.Sort(protos)
+	cfg.NextProtos = append(slices.Clip(cfg.NextProtos), protos...)
+	if ca := o.clientAuth; ca != nil {
+		ca.apply(cfg)
+	}
+	if rc := o.resumption; rc != nil && len(rc.TicketKeys) != 0 {
+		cfg.SetSessionTicketKeys(rc.TicketKeys)
+	}
+
+	return cfg
+}
+
+// quicgoConfig 在 WithQUICgoConfig 的基础上应用其它选项，返回的配置可以修改。
+func (o option) quicgoConfig() (*quic.Config, error) {
+	cfg := new(quic.Config)
+	if o.quicgo != nil {
+		cfg = o.quicgo.Clone()
+	}
+	if cfg.GetConfigForClient != nil {
+		return nil, errors.New("quick: quic.Config.GetConfigForClient is not supported")
+	}
+	if cfg.Tracer != nil && o.observer != nil {
+		return nil, errors.New("quick: quic.Config.Tracer cannot be used with WithObserver")
+	}
+	if o.idleTimeout > 0 {
+		cfg.MaxIdleTimeout = o.idleTimeout
+	}
+	if o.maxStreams > 0 {
+		cfg.MaxIncomingStreams = o.maxStreams
+	}
+	if rc := o.resumption; rc != nil && rc.Allow0RTT {
+		cfg.Allow0RTT = true
+	}
+
+	return cfg, nil
+}
+
+// quicxConfig 在 WithQUICxConfig 的基础上应用其它选项，返回的配置可以修改。
+func (o option) quicxConfig() *xquic.Config {
+	cfg := new(xquic.Config)
+	if o.quicx != nil {
+		*cfg = *o.quicx
+	}
+	cfg.TLSConfig = o.tlsConfig
+	if o.idleTimeout > 0 {
+		cfg.MaxIdleTimeout = o.idleTimeout
+	}
+	if o.maxStreams > 0 {
+		cfg.MaxBidiRemoteStreams = o.maxStreams
+	}
+
+	return cfg
+}
+
+// accepter 查找协议对应的处理器，没有时返回 nil。
+func (o option) accepter(proto string) muxproto.MUXAccepter {
+	if h, ok := o.routes[proto]; ok {
+		return h
+	}
+
+	return o.handler
+}
@@ -0,0 +1,121 @@
+package quicktest
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+	"github.com/xmx/aegis-control/quick"
+)
+
+type DialOption func(*dialOption)
+
+// WithALPN 客户端协商的应用层协议，默认为 quick.DefaultALPN。
+func WithALPN(proto string) DialOption {
+	return func(o *dialOption) {
+		o.alpn = proto
+	}
+}
+
+// WithClientCertificate 客户端证书，用于测试 quick.WithClientAuth。
+func WithClientCertificate(cert tls.Certificate) DialOption {
+	return func(o *dialOption) {
+		o.certs = append(o.certs, cert)
+	}
+}
+
+// WithFaults 注入故障。
+func WithFaults(f Faults) DialOption {
+	return func(o *dialOption) {
+		o.faults = f
+	}
+}
+
+type dialOption struct {
+	alpn   string
+	certs  []tls.Certificate
+	faults Faults
+}
+
+// Dial 建立一条到服务端的 QUIC 连接，连接握手完成后服务端才会把连接交给处理器。
+func (s *Server) Dial(ctx context.Context, opts ...DialOption) (muxconn.Muxer, error) {
+	opt := dialOption{alpn: quick.DefaultALPN}
+	for _, fn := range opts {
+		if fn != nil {
+			fn(&opt)
+		}
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", s.Addr)
+	if err != nil {
+		return nil, err
+	}
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	var pc net.PacketConn = udp
+	if opt.faults.lossy() {
+		pc = newLossyConn(udp, opt.faults)
+	}
+
+	tr := &quic.Transport{Conn: pc}
+	tlsCfg := &tls.Config{
+		RootCAs:      s.roots,
+		ServerName:   "localhost",
+		NextProtos:   []string{opt.alpn},
+		Certificates: opt.certs,
+	}
+	conn, err := tr.Dial(ctx, raddr, tlsCfg, &quic.Config{MaxIdleTimeout: 30 * time.Second})
+	if err != nil {
+		_ = tr.Close()
+		_ = udp.Close()
+		return nil, err
+	}
+
+	mux := &clientMuxer{
+		Muxer: muxconn.NewQUICgo(context.Background(), conn),
+		tr:    tr,
+		udp:   udp,
+		delay: opt.faults.CloseDelay,
+	}
+	// 服务端关闭连接后释放客户端的 UDP 端口。
+	context.AfterFunc(conn.Context(), func() { _ = mux.release() })
+
+	return mux, nil
+}
+
+// clientMuxer 客户端连接，关闭时一并释放独占的 UDP 端口。
+type clientMuxer struct {
+	muxconn.Muxer
+	tr    *quic.Transport
+	udp   net.PacketConn
+	delay time.Duration
+	once  sync.Once
+	err   error
+}
+
+// Close 设置了 Faults.CloseDelay 时立即返回，延迟后才真正关闭连接，
+// 模拟对端迟迟不关闭的情况。
+func (c *clientMuxer) Close() error {
+	if c.delay <= 0 {
+		return c.release()
+	}
+	time.AfterFunc(c.delay, func() { _ = c.release() })
+
+	return nil
+}
+
+func (c *clientMuxer) release() error {
+	c.once.Do(func() {
+		err := c.Muxer.Close()
+		c.err = errors.Join(err, c.tr.Close(), c.udp.Close())
+	})
+
+	return c.err
+}
@@ -0,0 +1,114 @@
+package quicktest
+
+import (
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// Direction 数据包的方向，以客户端为主体。
+type Direction int
+
+const (
+	Outgoing Direction = iota + 1 // 客户端发往服务端
+	Incoming                      // 服务端发往客户端
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Outgoing:
+		return "outgoing"
+	case Incoming:
+		return "incoming"
+	default:
+		return "unknown"
+	}
+}
+
+// Faults 客户端注入的故障，零值表示不注入故障。
+type Faults struct {
+	// LossRate 随机丢包率，取值 [0, 1]，两个方向分别计算。
+	LossRate float64
+
+	// Seed 随机丢包的种子，相同的种子下每个方向上第 n 个数据包是否丢弃是确定的。
+	Seed uint64
+
+	// Drop 自定义丢包规则，seq 为该方向上的数据包序号（从 0 开始），返回 true 时丢弃，
+	// 设置后忽略 LossRate。
+	Drop func(dir Direction, seq uint64) bool
+
+	// CloseDelay 客户端调用 Close 后延迟多久才真正关闭连接。
+	CloseDelay time.Duration
+}
+
+func (f Faults) lossy() bool {
+	return f.Drop != nil || f.LossRate > 0
+}
+
+func newLossyConn(pc net.PacketConn, f Faults) *lossyConn {
+	return &lossyConn{PacketConn: pc, drop: f.dropFunc()}
+}
+
+// dropFunc 丢包规则。随机丢包的结果只由种子、方向和该方向的序号决定，
+// 与两个方向收发的交错顺序无关，相同的种子总是产生相同的丢包结果。
+func (f Faults) dropFunc() func(Direction, uint64) bool {
+	if f.Drop != nil {
+		return f.Drop
+	}
+
+	return func(dir Direction, seq uint64) bool {
+		rnd := rand.New(rand.NewPCG(f.Seed^uint64(dir)<<56, seq))
+		return rnd.Float64() < f.LossRate
+	}
+}
+
+// lossyConn 按照规则丢弃收发的数据包。
+type lossyConn struct {
+	net.PacketConn
+	drop  func(Direction, uint64) bool
+	mutex sync.Mutex
+	seqs  [2]uint64 // 各方向的数据包序号
+}
+
+func (c *lossyConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil || !c.dropped(Incoming) {
+			return n, addr, err
+		}
+	}
+}
+
+// WriteTo 丢弃的数据包对调用方而言仍然是发送成功的。
+func (c *lossyConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if c.dropped(Outgoing) {
+		return len(p), nil
+	}
+
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func (c *lossyConn) dropped(dir Direction) bool {
+	c.mutex.Lock()
+	seq := c.seqs[dir-1]
+	c.seqs[dir-1]++
+	c.mutex.Unlock()
+
+	return c.drop(dir, seq)
+}
+
+// SetReadBuffer 和 SetWriteBuffer 透传给底层连接，避免 quic-go 告警。
+func (c *lossyConn) SetReadBuffer(n int) error {
+	if bs, ok := c.PacketConn.(interface{ SetReadBuffer(int) error }); ok {
+		return bs.SetReadBuffer(n)
+	}
+	return nil
+}
+
+func (c *lossyConn) SetWriteBuffer(n int) error {
+	if bs, ok := c.PacketConn.(interface{ SetWriteBuffer(int) error }); ok {
+		return bs.SetWriteBuffer(n)
+	}
+	return nil
+}
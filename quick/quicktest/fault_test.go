@@ -0,0 +1,94 @@
+package quicktest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+	"github.com/xmx/aegis-control/quick"
+)
+
+func TestFaultsDeterministic(t *testing.T) {
+	f := Faults{LossRate: 0.2, Seed: 42}
+	a, b := f.dropFunc(), f.dropFunc()
+
+	// a 按方向交替调用，b 先走完一个方向，结果必须一致。
+	const n = 10000
+	var want [2][n]bool
+	for seq := range uint64(n) {
+		want[0][seq] = a(Outgoing, seq)
+		want[1][seq] = a(Incoming, seq)
+	}
+	dropped := 0
+	for _, dir := range []Direction{Incoming, Outgoing} {
+		for seq := range uint64(n) {
+			got := b(dir, seq)
+			if got != want[dir-1][seq] {
+				t.Fatalf("%s packet %d: dropped = %v, want %v", dir, seq, got, want[dir-1][seq])
+			}
+			if got {
+				dropped++
+			}
+		}
+	}
+	if rate := float64(dropped) / (2 * n); rate < 0.18 || rate > 0.22 {
+		t.Fatalf("loss rate = %.3f, want about 0.2", rate)
+	}
+}
+
+type echoAccepter struct{}
+
+func (echoAccepter) AcceptMUX(mux muxconn.Muxer) {
+	for {
+		conn, err := mux.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			//goland:noinspection GoUnhandledErrorResult
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+	}
+}
+
+func TestDialRoundTrip(t *testing.T) {
+	for _, backend := range []quick.Backend{quick.BackendQUICgo, quick.BackendQUICx} {
+		t.Run(string(backend), func(t *testing.T) {
+			srv, err := NewServer(echoAccepter{}, quick.WithBackend(backend))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			mux, err := srv.Dial(ctx, WithFaults(Faults{LossRate: 0.05, Seed: 7}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			//goland:noinspection GoUnhandledErrorResult
+			defer mux.Close()
+
+			stream, err := mux.Open(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//goland:noinspection GoUnhandledErrorResult
+			defer stream.Close()
+
+			want := bytes.Repeat([]byte("quicktest"), 4096)
+			go func() { _, _ = stream.Write(want) }()
+			got := make([]byte, len(want))
+			if _, err = io.ReadFull(stream, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatal("echo mismatch")
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Package quicktest 提供 quick 服务的回环测试工具，类似 net/http/httptest。
+//
+// NewServer 在 127.0.0.1 的随机端口上启动 quick.Server 并自动生成证书，
+// Server.Dial 返回与之匹配的客户端 muxconn.Muxer，可以注入丢包、延迟关闭等故障，
+// 用于编写确定性的 muxproto.MUXAccepter 测试。
+package quicktest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/xmx/aegis-common/muxlink/muxproto"
+	"github.com/xmx/aegis-control/quick"
+)
+
+// NewServer 在回环地址的随机端口上启动 quick.Server，每个连接交由 h 处理。
+// opts 在默认配置之后应用，可以覆盖处理器、实现库等配置；覆盖 TLS 证书后 Dial 需要自行指定校验方式。
+func NewServer(h muxproto.MUXAccepter, opts ...quick.Option) (*Server, error) {
+	cert, leaf, err := generateCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg := &tls.Config{
+		MinVersion:   tls.VersionTLS13,
+		Certificates: []tls.Certificate{*cert},
+	}
+	opts = append([]quick.Option{quick.WithHandler(h), quick.WithTLSConfig(tlsCfg)}, opts...)
+	srv := quick.New("127.0.0.1:0", opts...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe(ctx) }()
+
+	// 等待监听完成，获取随机分配的端口。
+	var addr net.Addr
+	for addr == nil {
+		select {
+		case err = <-errc:
+			cancel()
+			return nil, err
+		case <-time.After(5 * time.Millisecond):
+		}
+		for _, a := range srv.LocalAddrs() {
+			if _, ok := a.(*net.UDPAddr); ok {
+				addr = a
+			}
+		}
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	return &Server{
+		Addr:        addr.String(),
+		Certificate: leaf,
+		srv:         srv,
+		roots:       roots,
+		cancel:      cancel,
+		errc:        errc,
+	}, nil
+}
+
+type Server struct {
+	Addr        string            // QUIC 监听地址，形如 127.0.0.1:12345
+	Certificate *x509.Certificate // 自动生成的服务端证书
+	srv         *quick.Server
+	roots       *x509.CertPool
+	cancel      context.CancelFunc
+	errc        chan error
+}
+
+// Quick 底层的 quick.Server，可以用来测试 Shutdown 等行为。
+func (s *Server) Quick() *quick.Server {
+	return s.srv
+}
+
+// Close 关闭服务和所有连接。
+func (s *Server) Close() error {
+	err := s.srv.Close()
+	s.cancel()
+	if exx := <-s.errc; exx != nil && !errors.Is(exx, quick.ErrServerClosed) {
+		err = errors.Join(err, exx)
+	}
+
+	return err
+}
+
+func generateCertificate() (*tls.Certificate, *x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		IsCA:                  true,
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "quicktest", Organization: []string{"aegis"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(0, 0, 1),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+		Leaf:        leaf,
+	}
+
+	return cert, leaf, nil
+}
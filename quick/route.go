@@ -0,0 +1,38 @@
+package quick
+
+import (
+	"net"
+	"strconv"
+)
+
+// CloseNoRoute 协商的应用层协议没有处理器时，关闭 QUIC 连接使用的应用错误码。
+const CloseNoRoute = 0x1a
+
+// RouteError 协商的应用层协议没有对应的处理器。
+type RouteError struct {
+	Proto string
+}
+
+func (e *RouteError) Error() string {
+	return "no handler for application protocol " + strconv.Quote(e.Proto)
+}
+
+// routeFunc 握手完成后检查协商的应用层协议是否有处理器，返回错误时拒绝该连接。
+type routeFunc func(network, proto string, local, remote net.Addr) error
+
+func (s *Server) route(network, proto string, local, remote net.Addr) error {
+	if s.opt.accepter(proto) != nil {
+		return nil
+	}
+
+	err := &RouteError{Proto: proto}
+	s.observer().OnHandshakeError(&HandshakeError{
+		Kind:    HandshakeALPN,
+		Network: network,
+		Local:   local,
+		Remote:  remote,
+		Err:     err,
+	})
+
+	return err
+}
@@ -0,0 +1,99 @@
+package quick_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+	"github.com/xmx/aegis-control/quick"
+)
+
+// tagAccepter 在每个子流上回复 tag 后关闭，用来区分连接交由哪个处理器处理。
+type tagAccepter string
+
+func (h tagAccepter) AcceptMUX(mux muxconn.Muxer) {
+	for {
+		conn, err := mux.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte(h))
+		_ = conn.Close()
+	}
+}
+
+// readTag 打开一条子流并读取处理器回复的 tag。
+func readTag(ctx context.Context, t *testing.T, mux muxconn.Muxer) string {
+	t.Helper()
+	stream, err := mux.Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer stream.Close()
+
+	_ = stream.SetDeadline(time.Now().Add(5 * time.Second))
+	// QUIC 的流在发送数据后对端才能感知。
+	if _, err = stream.Write([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	tag, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(tag)
+}
+
+// TestRoute 注册两个协议，QUIC 按照 ALPN、TCP 按照 alpn 参数分发到各自的处理器。
+func TestRoute(t *testing.T) {
+	cert := selfSigned(t)
+	routes := []string{"proto-a", "proto-b"}
+	for _, backend := range backends {
+		t.Run(string(backend), func(t *testing.T) {
+			tlsCfg := &tls.Config{MinVersion: tls.VersionTLS13, Certificates: []tls.Certificate{cert}}
+			srv := quick.New("127.0.0.1:0", quick.WithBackend(backend), quick.WithTLSConfig(tlsCfg),
+				quick.WithTCPFallback(""),
+				quick.WithRoute(routes[0], tagAccepter(routes[0])),
+				quick.WithRoute(routes[1], tagAccepter(routes[1])))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			errc := make(chan error, 1)
+			go func() { errc <- srv.ListenAndServe(ctx) }()
+			defer func() {
+				_ = srv.Close()
+				<-errc
+			}()
+			waitFor(t, "server listening", func() bool { return len(srv.LocalAddrs()) == 2 })
+			udpAddr, tcpAddr := localAddr(t, srv, false), localAddr(t, srv, true)
+
+			for _, proto := range routes {
+				clientCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{proto}}
+				conn, err := quic.DialAddr(ctx, udpAddr, clientCfg, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				mux := muxconn.NewQUICgo(context.Background(), conn)
+				if tag := readTag(ctx, t, mux); tag != proto {
+					t.Fatalf("quic %s reached handler %q", proto, tag)
+				}
+				_ = mux.Close()
+
+				mux = dialTCP(ctx, t, tcpAddr, proto)
+				if tag := readTag(ctx, t, mux); tag != proto {
+					t.Fatalf("tcp %s reached handler %q", proto, tag)
+				}
+			}
+
+			// 未指定 alpn 参数时为 NextProtos 的第一个协议。
+			if tag := readTag(ctx, t, dialTCP(ctx, t, tcpAddr, "")); tag != routes[0] {
+				t.Fatalf("tcp without alpn reached handler %q, want %q", tag, routes[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,538 @@
+package quick
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+	"github.com/xmx/aegis-common/muxlink/muxproto"
+)
+
+// ErrServerClosed 调用 Shutdown 或 Close 后 ListenAndServe 返回的错误。
+var ErrServerClosed = errors.New("quick: Server closed")
+
+// New 创建 QUIC 服务，addr 为空时监听 :443，可以调用 SetAddrs 修改监听地址。
+func New(addr string, opts ...Option) *Server {
+	if addr == "" {
+		addr = ":443"
+	}
+
+	opt := newOption(opts)
+	srv := &Server{
+		addrs:   []string{addr},
+		opt:     opt,
+		done:    make(chan struct{}),
+		drained: make(chan struct{}),
+	}
+	if cfg := opt.admission; cfg != nil {
+		srv.adm = newAdmission(*cfg, addr)
+	}
+
+	return srv
+}
+
+type Server struct {
+	opt       option
+	adm       *admission
+	bindMutex sync.Mutex // 串行化监听地址的变更
+	mutex     sync.Mutex
+	addrs     []string            // 期望监听的地址
+	serveCtx  context.Context     // ListenAndServe 运行期间不为 nil，退出时取消
+	connCtx   context.Context     // 连接上下文的 parent，即调用方传入的 ctx，服务关闭时不会取消
+	binds     map[string]*binding // 已经监听的地址
+	listeners map[listener]struct{}
+	conns     map[muxconn.Muxer]struct{} // 正在处理的连接
+	onClose   []func()
+	closed    atomic.Bool
+	doneOnce  sync.Once
+	done      chan struct{} // Shutdown 或 Close 后关闭
+	drainOnce sync.Once
+	drained   chan struct{} // 服务关闭并且连接全部结束后关闭
+}
+
+// binding 同一个地址上的监听。
+type binding struct {
+	addr      string
+	parent    context.Context // 连接上下文的 parent，移除地址或 Shutdown 时不会取消
+	listeners []listener
+	cancel    context.CancelFunc
+	serving   sync.WaitGroup // accept 协程
+	conns     sync.WaitGroup // 从该地址接入且正在处理的连接
+}
+
+// BindError 监听地址失败。
+type BindError struct {
+	Addr string
+	Err  error
+}
+
+func (e *BindError) Error() string {
+	return "quick: listen " + e.Addr + ": " + e.Err.Error()
+}
+
+func (e *BindError) Unwrap() error { return e.Err }
+
+// Addrs 期望监听的地址。
+func (s *Server) Addrs() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return slices.Clone(s.addrs)
+}
+
+// LocalAddrs 当前实际监听的地址，监听 :0 等随机端口时可以用来获取端口。
+func (s *Server) LocalAddrs() []net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rets := make([]net.Addr, 0, len(s.listeners))
+	for _, b := range s.binds {
+		for _, lis := range b.listeners {
+			rets = append(rets, lis.addr())
+		}
+	}
+
+	return rets
+}
+
+// SetAddrs 修改监听地址，重复和空的地址会被忽略。
+//
+// 服务运行期间会立即生效：新增的地址开始监听，移除的地址停止接受新连接，
+// 已经建立的连接不受影响，待其全部结束后再释放监听。返回监听失败的地址及原因，
+// 失败的地址会在下次调用 SetAddrs 时重试。
+//
+// 注意：移除的地址在连接结束前仍然占用端口，此时重新加入该地址会监听失败。
+func (s *Server) SetAddrs(addrs ...string) map[string]error {
+	uniq := make(map[string]struct{}, len(addrs))
+	wants := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if _, exists := uniq[addr]; exists || addr == "" {
+			continue
+		}
+		uniq[addr] = struct{}{}
+		wants = append(wants, addr)
+	}
+
+	s.mutex.Lock()
+	s.addrs = wants
+	s.mutex.Unlock()
+
+	return s.rebind()
+}
+
+// Close 立即关闭所有监听和正在处理的连接，如需平滑关闭请使用 Shutdown。
+func (s *Server) Close() error {
+	s.markClosed()
+	err := s.closeListeners()
+
+	s.mutex.Lock()
+	for mux := range s.conns {
+		_ = mux.Close()
+	}
+	s.mutex.Unlock()
+
+	return err
+}
+
+// Shutdown 平滑关闭服务：先停止所有监听不再接受新连接，然后执行 RegisterOnShutdown
+// 注册的回调通知处理器，等待正在处理的连接全部结束。ctx 结束时仍未结束的连接会被强制关闭，
+// 并返回 ctx 的错误。
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.markClosed()
+	err := s.stopListeners()
+	defer func() { _ = s.closeListeners() }()
+
+	s.mutex.Lock()
+	for _, f := range s.onClose {
+		go f()
+	}
+	s.checkDrained()
+	s.mutex.Unlock()
+
+	select {
+	case <-s.drained:
+		return err
+	case <-ctx.Done():
+		s.mutex.Lock()
+		for mux := range s.conns {
+			_ = mux.Close()
+		}
+		s.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+// RegisterOnShutdown 注册 Shutdown 时执行的回调，用于通知处理器迁移或结束会话，
+// 回调在独立的协程中执行。
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.mutex.Lock()
+	s.onClose = append(s.onClose, f)
+	s.mutex.Unlock()
+}
+
+// NumConns 正在处理的连接数。
+func (s *Server) NumConns() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.conns)
+}
+
+// stopListeners 停止所有监听接受新连接。
+func (s *Server) stopListeners() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	errs := make([]error, 0, len(s.listeners))
+	for lis := range s.listeners {
+		errs = append(errs, lis.stop())
+	}
+
+	return errors.Join(errs...)
+}
+
+// closeListeners 释放所有监听。
+func (s *Server) closeListeners() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	errs := make([]error, 0, len(s.listeners))
+	for lis := range s.listeners {
+		errs = append(errs, lis.close())
+		delete(s.listeners, lis)
+	}
+	clear(s.binds)
+
+	return errors.Join(errs...)
+}
+
+func (s *Server) markClosed() {
+	s.closed.Store(true)
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// ListenAndServe 监听所有地址并处理连接，直到 ctx 取消或服务关闭。
+//
+// 启动时任意一个地址监听失败都会释放全部监听，并返回由 *BindError 组成的错误；
+// 运行期间某个地址的监听出错时只会释放该地址，调用 SetAddrs 可以重新监听。
+// 调用 Shutdown 或 Close 后返回 ErrServerClosed，此时监听由 Shutdown 或 Close 负责释放。
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if s.closed.Load() {
+		return ErrServerClosed
+	}
+
+	// 退出时只停止 accept，连接的上下文继承自调用方的 ctx，
+	// 这样 Shutdown 期间正在处理的连接不会随着 ListenAndServe 返回而被取消。
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s.mutex.Lock()
+	if s.serveCtx != nil {
+		s.mutex.Unlock()
+		return errors.New("quick: Server is already serving")
+	}
+	s.serveCtx, s.connCtx = ctx, parent
+	s.mutex.Unlock()
+
+	errs := s.rebind()
+	if len(errs) != 0 {
+		s.unbindAll(cancel)
+		if s.closed.Load() {
+			return ErrServerClosed
+		}
+		bes := make([]error, 0, len(errs))
+		for addr, err := range errs {
+			bes = append(bes, &BindError{Addr: addr, Err: err})
+		}
+		return errors.Join(bes...)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+	}
+	s.unbindAll(cancel)
+	if s.closed.Load() {
+		return ErrServerClosed
+	}
+
+	return ctx.Err()
+}
+
+// unbindAll ListenAndServe 退出时取消所有监听并等待 accept 协程结束。
+// 服务未关闭时由这里释放监听，否则交由 Shutdown 或 Close 释放。
+func (s *Server) unbindAll(cancel context.CancelFunc) {
+	s.bindMutex.Lock()
+	defer s.bindMutex.Unlock()
+
+	cancel()
+	s.mutex.Lock()
+	s.serveCtx, s.connCtx = nil, nil
+	binds := make([]*binding, 0, len(s.binds))
+	for addr, b := range s.binds {
+		binds = append(binds, b)
+		delete(s.binds, addr)
+	}
+	s.mutex.Unlock()
+
+	for _, b := range binds {
+		if !s.closed.Load() {
+			for _, lis := range b.listeners {
+				if s.untrackListener(lis) {
+					_ = lis.close()
+				}
+			}
+		}
+		b.serving.Wait()
+	}
+}
+
+// rebind 按照期望的地址增减监听，返回监听失败的地址。
+func (s *Server) rebind() map[string]error {
+	s.bindMutex.Lock()
+	defer s.bindMutex.Unlock()
+
+	s.mutex.Lock()
+	ctx, parent, addrs := s.serveCtx, s.connCtx, s.addrs
+	if ctx == nil {
+		s.mutex.Unlock()
+		return nil
+	}
+	removes := make([]*binding, 0, 4)
+	for addr, b := range s.binds {
+		if !slices.Contains(addrs, addr) {
+			removes = append(removes, b)
+			delete(s.binds, addr)
+		}
+	}
+	adds := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if _, exists := s.binds[addr]; !exists {
+			adds = append(adds, addr)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, b := range removes {
+		s.unbind(b)
+	}
+	errs := make(map[string]error, len(adds))
+	for _, addr := range adds {
+		if err := s.bind(ctx, parent, addr); err != nil {
+			errs[addr] = err
+		}
+	}
+
+	return errs
+}
+
+// bind 监听 addr 并启动 accept 协程，ctx 取消时停止 accept，parent 为连接上下文的 parent。
+func (s *Server) bind(ctx, parent context.Context, addr string) error {
+	lises, err := s.listen(parent, addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	b := &binding{addr: addr, parent: parent, listeners: lises, cancel: cancel}
+	b.serving.Add(len(lises))
+
+	s.mutex.Lock()
+	if s.closed.Load() || ctx.Err() != nil {
+		s.mutex.Unlock()
+		cancel()
+		for _, lis := range lises {
+			_ = lis.close()
+		}
+		return ErrServerClosed
+	}
+	if s.binds == nil {
+		s.binds = make(map[string]*binding, 4)
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[listener]struct{}, 8)
+	}
+	s.binds[addr] = b
+	for _, lis := range lises {
+		s.listeners[lis] = struct{}{}
+	}
+	s.mutex.Unlock()
+
+	for _, lis := range lises {
+		go func() {
+			defer b.serving.Done()
+			if err := s.serve(ctx, b, lis); err != nil && ctx.Err() == nil && !s.closed.Load() {
+				// 监听出错，释放该地址的监听，下次 rebind 时会重新监听。
+				s.mutex.Lock()
+				owned := s.binds[addr] == b
+				if owned {
+					delete(s.binds, addr)
+				}
+				s.mutex.Unlock()
+				if owned {
+					s.unbind(b)
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+// unbind 停止地址上的监听接受新连接，已经建立的连接全部结束后释放监听。
+func (s *Server) unbind(b *binding) {
+	b.cancel()
+	for _, lis := range b.listeners {
+		_ = lis.stop()
+	}
+
+	go func() {
+		b.serving.Wait()
+		b.conns.Wait()
+		for _, lis := range b.listeners {
+			if s.untrackListener(lis) {
+				_ = lis.close()
+			}
+		}
+	}()
+}
+
+func (s *Server) serve(ctx context.Context, b *binding, lis listener) error {
+	var tempDelay time.Duration // how long to sleep on accept failure
+	for {
+		sess, err := lis.accept(ctx)
+		if err != nil {
+			if s.closed.Load() {
+				return ErrServerClosed
+			}
+			if exx := ctx.Err(); exx != nil {
+				return exx
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				s.observer().OnAcceptError(lis.addr(), err, true)
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				tempDelay = min(tempDelay, time.Second)
+				_ = timeSleep(ctx, tempDelay)
+				continue
+			}
+			s.observer().OnAcceptError(lis.addr(), err, false)
+
+			return err
+		}
+		tempDelay = 0
+
+		mux := withContext(b.parent, sess)
+		release := func() {}
+		if s.adm != nil {
+			if release, err = s.adm.acquire(mux.RemoteAddr()); err != nil {
+				mux.cancel()
+				_ = mux.Close()
+				s.observer().OnHandshakeError(&HandshakeError{
+					Kind:    HandshakeRejected,
+					Network: lis.addr().Network(),
+					Local:   lis.addr(),
+					Remote:  mux.RemoteAddr(),
+					Err:     err,
+				})
+				continue
+			}
+		}
+
+		if !s.trackConn(mux, true) {
+			release()
+			mux.cancel()
+			_ = mux.Close()
+			return ErrServerClosed
+		}
+
+		b.conns.Add(1)
+		go func() {
+			defer b.conns.Done()
+			s.handle(mux, s.opt.accepter(sess.proto), release)
+		}()
+	}
+}
+
+func (s *Server) handle(mux *ctxMuxer, h muxproto.MUXAccepter, release func()) {
+	defer func() {
+		mux.cancel()
+		_ = mux.Close()
+		s.trackConn(mux, false)
+		release()
+	}()
+
+	if h != nil {
+		h.AcceptMUX(mux)
+	}
+}
+
+func (s *Server) observer() Observer {
+	if obs := s.opt.observer; obs != nil {
+		return obs
+	}
+
+	return nopObserver{}
+}
+
+// trackConn 记录正在处理的连接，服务已关闭时拒绝记录新连接并返回 false。
+func (s *Server) trackConn(mux muxconn.Muxer, add bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !add {
+		delete(s.conns, mux)
+		s.checkDrained()
+		return true
+	}
+	if s.closed.Load() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[muxconn.Muxer]struct{}, 64)
+	}
+	s.conns[mux] = struct{}{}
+
+	return true
+}
+
+// checkDrained 服务关闭后连接全部结束时通知 Shutdown，调用方需持有 s.mutex。
+//
+// 服务关闭后 trackConn 不再记录新连接，连接数归零后不会再增加。
+func (s *Server) checkDrained() {
+	if s.closed.Load() && len(s.conns) == 0 {
+		s.drainOnce.Do(func() { close(s.drained) })
+	}
+}
+
+// untrackListener 移除监听记录，返回 false 表示监听已经被释放。
+func (s *Server) untrackListener(lis listener) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, exists := s.listeners[lis]
+	delete(s.listeners, lis)
+
+	return exists
+}
+
+func timeSleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
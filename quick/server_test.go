@@ -0,0 +1,362 @@
+package quick_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+	"github.com/xmx/aegis-common/muxlink/muxconn"
+	"github.com/xmx/aegis-control/quick"
+	"github.com/xmx/aegis-control/quick/quicktest"
+)
+
+// echoAccepter 将每个子流收到的数据原样写回。
+type echoAccepter struct{}
+
+func (echoAccepter) AcceptMUX(mux muxconn.Muxer) {
+	for {
+		conn, err := mux.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			//goland:noinspection GoUnhandledErrorResult
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+	}
+}
+
+var backends = []quick.Backend{quick.BackendQUICgo, quick.BackendQUICx}
+
+// dialTCP 按照 muxconn 客户端的方式建立 TCP 通道：wss 升级后使用 smux 多路复用，
+// alpn 不为空时通过升级地址的 alpn 参数指定应用层协议。
+func dialTCP(ctx context.Context, t *testing.T, addr, alpn string) muxconn.Muxer {
+	t.Helper()
+	query := url.Values{"protocol": {"smux"}}
+	if alpn != "" {
+		query.Set("alpn", alpn)
+	}
+	reqURL := &url.URL{Scheme: "wss", Host: addr, Path: quick.DefaultTunnelPath, RawQuery: query.Encode()}
+	d := &websocket.Dialer{
+		HandshakeTimeout: 5 * time.Second,
+		TLSClientConfig:  &tls.Config{InsecureSkipVerify: true},
+	}
+	ws, _, err := d.DialContext(ctx, reqURL.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux, err := muxconn.NewSMUX(context.Background(), ws.NetConn(), nil, false)
+	if err != nil {
+		_ = ws.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = mux.Close() })
+
+	return mux
+}
+
+// localAddr 返回服务监听的 TCP 或 UDP 地址。
+func localAddr(t *testing.T, srv *quick.Server, tcp bool) string {
+	t.Helper()
+	waitFor(t, "server listening", func() bool { return len(srv.LocalAddrs()) != 0 })
+	for _, a := range srv.LocalAddrs() {
+		if _, ok := a.(*net.TCPAddr); ok == tcp {
+			return a.String()
+		}
+	}
+	t.Fatalf("no listener (tcp=%t) in %v", tcp, srv.LocalAddrs())
+
+	return ""
+}
+
+func TestLoopback(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []quick.Option
+	}{
+		{name: string(quick.BackendQUICgo), opts: []quick.Option{quick.WithBackend(quick.BackendQUICgo)}},
+		{name: string(quick.BackendQUICx), opts: []quick.Option{quick.WithBackend(quick.BackendQUICx)}},
+		{name: "quic-go-0rtt", opts: []quick.Option{
+			quick.WithBackend(quick.BackendQUICgo),
+			quick.WithResumption(quick.ResumptionConfig{Allow0RTT: true}),
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, err := quicktest.NewServer(echoAccepter{}, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			mux, err := srv.Dial(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//goland:noinspection GoUnhandledErrorResult
+			defer mux.Close()
+
+			for i := range 3 {
+				stream, err := mux.Open(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := bytes.Repeat([]byte{byte('a' + i)}, 64<<10)
+				go func() { _, _ = stream.Write(want) }()
+				got := make([]byte, len(want))
+				if _, err = io.ReadFull(stream, got); err != nil {
+					t.Fatalf("stream %d: %v", i, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("stream %d: echo mismatch", i)
+				}
+				_ = stream.Close()
+			}
+
+			if n := srv.Quick().NumConns(); n != 1 {
+				t.Fatalf("NumConns() = %d, want 1", n)
+			}
+		})
+	}
+}
+
+// holdAccepter 记录接入的连接数，并一直占用连接直到 release 关闭或连接被关闭。
+type holdAccepter struct {
+	accepted atomic.Int64
+	release  chan struct{}
+}
+
+func (h *holdAccepter) AcceptMUX(mux muxconn.Muxer) {
+	h.accepted.Add(1)
+	closed := make(chan struct{})
+	go func() {
+		for {
+			conn, err := mux.Accept()
+			if err != nil {
+				close(closed)
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	select {
+	case <-h.release:
+	case <-closed:
+	}
+}
+
+// waitFor 轮询 cond 直到返回 true，超时后测试失败。
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(string(backend), func(t *testing.T) {
+			h := &holdAccepter{release: make(chan struct{})}
+			srv, err := quicktest.NewServer(h, quick.WithBackend(backend))
+			if err != nil {
+				t.Fatal(err)
+			}
+			//goland:noinspection GoUnhandledErrorResult
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			mux, err := srv.Dial(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//goland:noinspection GoUnhandledErrorResult
+			defer mux.Close()
+			waitFor(t, "connection tracked", func() bool { return srv.Quick().NumConns() == 1 })
+
+			done := make(chan error, 1)
+			go func() { done <- srv.Quick().Shutdown(ctx) }()
+
+			// 关闭期间不再接受新连接，已有的连接不受影响。
+			dialCtx, dialCancel := context.WithTimeout(ctx, time.Second)
+			defer dialCancel()
+			if late, exx := srv.Dial(dialCtx); exx == nil {
+				_, exx = late.Open(dialCtx)
+				_ = late.Close()
+			}
+			if n := h.accepted.Load(); n != 1 {
+				t.Fatalf("accepted %d connections during shutdown, want 1", n)
+			}
+			select {
+			case err = <-done:
+				t.Fatalf("Shutdown returned %v before the connection finished", err)
+			default:
+			}
+			if n := srv.Quick().NumConns(); n != 1 {
+				t.Fatalf("NumConns() = %d during shutdown, want 1", n)
+			}
+
+			close(h.release)
+			select {
+			case err = <-done:
+				if err != nil {
+					t.Fatalf("Shutdown() = %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Shutdown did not return after the connection finished")
+			}
+			if n := srv.Quick().NumConns(); n != 0 {
+				t.Fatalf("NumConns() = %d after shutdown", n)
+			}
+		})
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(string(backend), func(t *testing.T) {
+			h := &holdAccepter{release: make(chan struct{})}
+			srv, err := quicktest.NewServer(h, quick.WithBackend(backend))
+			if err != nil {
+				t.Fatal(err)
+			}
+			//goland:noinspection GoUnhandledErrorResult
+			defer srv.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			mux, err := srv.Dial(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//goland:noinspection GoUnhandledErrorResult
+			defer mux.Close()
+			waitFor(t, "connection tracked", func() bool { return srv.Quick().NumConns() == 1 })
+
+			// 连接一直不结束，ctx 到期后强制关闭。
+			shutCtx, shutCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer shutCancel()
+			if err = srv.Quick().Shutdown(shutCtx); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("Shutdown() = %v, want context.DeadlineExceeded", err)
+			}
+			waitFor(t, "connection closed", func() bool { return srv.Quick().NumConns() == 0 })
+			if _, err = mux.Accept(); err == nil {
+				t.Fatal("client connection still open after forced close")
+			}
+		})
+	}
+}
+
+// echoOnce 在 conn 上打开一条流并校验回显。
+func echoOnce(ctx context.Context, conn *quic.Conn) error {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(dl)
+	}
+	want := []byte("ping")
+	if _, err = stream.Write(want); err != nil {
+		return err
+	}
+	_ = stream.Close()
+	got, err := io.ReadAll(stream)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return errors.New("echo mismatch: " + string(got))
+	}
+
+	return nil
+}
+
+// TestSetAddrsLive 服务运行期间修改监听地址：新地址开始接受连接，移除的地址不再接受新连接，
+// 已经建立的连接不受影响，连接结束后移除的地址可以重新加入。
+func TestSetAddrsLive(t *testing.T) {
+	cert := selfSigned(t)
+	for _, backend := range backends {
+		t.Run(string(backend), func(t *testing.T) {
+			oldAddr, newAddr := freeUDPAddr(t), freeUDPAddr(t)
+			tlsCfg := &tls.Config{MinVersion: tls.VersionTLS13, Certificates: []tls.Certificate{cert}}
+			srv := quick.New(oldAddr, quick.WithBackend(backend), quick.WithTLSConfig(tlsCfg),
+				quick.WithHandler(echoAccepter{}))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+			errc := make(chan error, 1)
+			go func() { errc <- srv.ListenAndServe(ctx) }()
+			defer func() {
+				_ = srv.Close()
+				<-errc
+			}()
+
+			old := dialQUICgo(ctx, t, oldAddr)
+			if err := echoOnce(ctx, old); err != nil {
+				t.Fatal(err)
+			}
+
+			if errs := srv.SetAddrs(newAddr); len(errs) != 0 {
+				t.Fatalf("SetAddrs() = %v", errs)
+			}
+			if addrs := srv.Addrs(); len(addrs) != 1 || addrs[0] != newAddr {
+				t.Fatalf("Addrs() = %v", addrs)
+			}
+
+			// 新地址接受连接。
+			fresh := dialQUICgo(ctx, t, newAddr)
+			defer func() { _ = fresh.CloseWithError(0, "") }()
+			if err := echoOnce(ctx, fresh); err != nil {
+				t.Fatal(err)
+			}
+
+			// 移除的地址不再接受新连接。
+			dialCtx, dialCancel := context.WithTimeout(ctx, 500*time.Millisecond)
+			clientCfg := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{quick.DefaultALPN}}
+			if late, err := quic.DialAddr(dialCtx, oldAddr, clientCfg, nil); err == nil {
+				if err = echoOnce(dialCtx, late); err == nil {
+					t.Fatal("removed address still accepts connections")
+				}
+				_ = late.CloseWithError(0, "")
+			}
+			dialCancel()
+
+			// 已经建立的连接不受影响。
+			if err := echoOnce(ctx, old); err != nil {
+				t.Fatalf("existing connection broken after SetAddrs: %v", err)
+			}
+			if n := srv.NumConns(); n != 2 {
+				t.Fatalf("NumConns() = %d, want 2", n)
+			}
+
+			// 旧连接结束后释放监听，原地址可以重新加入。
+			_ = old.CloseWithError(0, "")
+			waitFor(t, "old address released", func() bool {
+				return len(srv.SetAddrs(oldAddr, newAddr)) == 0
+			})
+			again := dialQUICgo(ctx, t, oldAddr)
+			defer func() { _ = again.CloseWithError(0, "") }()
+			if err := echoOnce(ctx, again); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
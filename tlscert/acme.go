@@ -0,0 +1,549 @@
+package tlscert
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// ACMEALPN TLS-ALPN-01 验证使用的应用层协议（RFC 8737）。
+const ACMEALPN = acme.ALPNProto
+
+// ACME 验证方式。
+const (
+	ChallengeTLSALPN01 = "tls-alpn-01"
+	ChallengeHTTP01    = "http-01"
+)
+
+// ACMEStore ACME 签发证书的存储，repository.Certificate 实现了该接口。
+type ACMEStore interface {
+	// LoadCertificate 获取名为 name 的证书，不存在时返回 nil, nil。
+	LoadCertificate(ctx context.Context, name string) (*tls.Certificate, error)
+
+	// SaveCertificate 保存名为 name 的证书，已存在时替换，chain 和 key 均为 PEM 格式。
+	SaveCertificate(ctx context.Context, name string, chain, key []byte) error
+
+	// PutChallenge 保存验证的应答，ttl 后过期。多个 broker 共享同一个域名时，
+	// ACME 服务的验证请求可能落到任意一个 broker 上，所以应答必须保存在共享的存储中。
+	PutChallenge(ctx context.Context, key, value string, ttl time.Duration) error
+
+	// GetChallenge 查询验证的应答，不存在或已过期时返回空字符串。
+	GetChallenge(ctx context.Context, key string) (string, error)
+
+	// DeleteChallenge 删除验证的应答。
+	DeleteChallenge(ctx context.Context, key string) error
+
+	// AcquireLease 获取或续约名为 name 的租约，被其它 holder 持有且未过期时返回 false。
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+}
+
+const (
+	// acmeLease 续期租约的名字，多个 broker 中只有租约的持有者执行续期。
+	acmeLease = "acme"
+
+	// acmeLeaseTTL 租约的有效期，要大于一次签发的超时时间。
+	acmeLeaseTTL = 10 * time.Minute
+
+	// acmeChallengeTTL 验证应答的有效期，与一次签发的超时时间一致。
+	acmeChallengeTTL = 5 * time.Minute
+)
+
+// idPeACMEIdentifier TLS-ALPN-01 验证证书中的扩展（RFC 8737 第 3 节）。
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// ACMEConfig ACME（RFC 8555）自动签发配置。
+type ACMEConfig struct {
+	// DirectoryURL ACME 服务的目录地址，为空时默认为 Let's Encrypt 正式环境。
+	// 测试时可以指向 Pebble 等本地服务，例如 https://127.0.0.1:14000/dir。
+	DirectoryURL string
+
+	// Email 账户的联系邮箱，可以为空。
+	Email string
+
+	// AccountKey 账户密钥，为 nil 时每次启动都会生成新的密钥并注册新账户。
+	AccountKey crypto.Signer
+
+	// HTTPClient 访问 ACME 服务的客户端，为 nil 时使用 http.DefaultClient。
+	// 测试时需要信任 Pebble 的自签根证书。
+	HTTPClient *http.Client
+
+	// Names 需要签发证书的域名，每个域名单独签发一张证书，存储时的名字为 "acme:" + 域名。
+	// HTTP-01 和 TLS-ALPN-01 均不支持通配符域名。
+	Names []string
+
+	// Challenges 按照优先级排列的验证方式，为空时默认 TLS-ALPN-01 优先于 HTTP-01。
+	Challenges []string
+
+	// RenewBefore 证书在 NotAfter 之前多久续期，小于等于 0 时为证书有效期的 1/3。
+	RenewBefore time.Duration
+
+	// CheckInterval 检查证书是否需要续期的间隔，小于等于 0 时默认为 1h。
+	CheckInterval time.Duration
+
+	// Store 证书存储，必须设置。
+	Store ACMEStore
+}
+
+// ACMEChallenger 应答 ACME 服务的验证请求，由现有的监听调用，例如 quick.WithACME。
+type ACMEChallenger interface {
+	// GetChallengeCertificate 返回 TLS-ALPN-01 验证证书，hello 不是验证请求时返回 nil, nil。
+	GetChallengeCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	// HTTPHandler 应答 HTTP-01 验证请求，其它请求交给 next，next 为 nil 时返回 404。
+	HTTPHandler(next http.Handler) http.Handler
+}
+
+// ACMEIssuer ACME 证书签发器，签发的证书写入 ACMEStore，再由 Matcher 刷新证书池后生效。
+type ACMEIssuer interface {
+	ACMEChallenger
+
+	// Renew 检查所有域名的证书，不存在或者即将过期时签发新证书。
+	Renew(ctx context.Context) error
+
+	// Run 立即执行一次 Renew，之后每隔 CheckInterval 执行一次，直到 ctx 取消。
+	Run(ctx context.Context) error
+}
+
+func NewACMEIssuer(cfg ACMEConfig, log *slog.Logger) (ACMEIssuer, error) {
+	if cfg.Store == nil {
+		return nil, errors.New("tlscert: acme store is required")
+	}
+	names := make([]string, 0, len(cfg.Names))
+	for _, name := range cfg.Names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case name == "":
+			continue
+		case strings.HasPrefix(name, "*."):
+			return nil, errors.New("tlscert: acme wildcard name is not supported: " + name)
+		case net.ParseIP(name) != nil:
+			return nil, errors.New("tlscert: acme ip address is not supported: " + name)
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("tlscert: acme names are required")
+	}
+	cfg.Names = names
+	if cfg.DirectoryURL == "" {
+		cfg.DirectoryURL = acme.LetsEncryptURL
+	}
+	if len(cfg.Challenges) == 0 {
+		cfg.Challenges = []string{ChallengeTLSALPN01, ChallengeHTTP01}
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = time.Hour
+	}
+
+	holder := make([]byte, 8)
+	_, _ = rand.Read(holder)
+
+	return &acmeIssuer{
+		cfg:    cfg,
+		log:    log,
+		holder: hex.EncodeToString(holder),
+		certs:  make(map[string]*tls.Certificate, 4),
+	}, nil
+}
+
+type acmeIssuer struct {
+	cfg    ACMEConfig
+	log    *slog.Logger
+	holder string       // 续期租约的持有者标识，每个签发器随机生成
+	mutex  sync.Mutex   // 保护 client，同一时刻只有一个签发流程
+	client *acme.Client // 已注册账户的客户端
+
+	certMutex sync.Mutex
+	certs     map[string]*tls.Certificate // 域名 + key authorization -> TLS-ALPN-01 验证证书
+}
+
+func (a *acmeIssuer) GetChallengeCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if !slices.Contains(hello.SupportedProtos, ACMEALPN) {
+		return nil, nil
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	ctx, cancel := context.WithTimeout(hello.Context(), 5*time.Second)
+	defer cancel()
+
+	keyAuth, err := a.cfg.Store.GetChallenge(ctx, tlsALPNChallengeKey(name))
+	if err != nil {
+		return nil, err
+	} else if keyAuth == "" {
+		return nil, errors.New("tlscert: no acme challenge for " + name)
+	}
+
+	return a.challengeCert(name, keyAuth)
+}
+
+// challengeCert 根据 key authorization 生成 TLS-ALPN-01 验证证书，签发证书的 broker 与
+// 应答验证的 broker 可以不是同一个，所以只共享 key authorization，证书在本地生成并缓存。
+func (a *acmeIssuer) challengeCert(domain, keyAuth string) (*tls.Certificate, error) {
+	key := domain + "\x00" + keyAuth
+	a.certMutex.Lock()
+	defer a.certMutex.Unlock()
+	if crt := a.certs[key]; crt != nil {
+		return crt, nil
+	}
+
+	sum := sha256.Sum256([]byte(keyAuth))
+	ext, err := asn1.Marshal(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: domain},
+		DNSNames:              []string{domain},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		ExtraExtensions: []pkix.Extension{
+			{Id: idPeACMEIdentifier, Critical: true, Value: ext},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
+	if err != nil {
+		return nil, err
+	}
+
+	// 验证结束后不再需要旧的证书，缓存只保留少量条目。
+	if len(a.certs) >= 16 {
+		clear(a.certs)
+	}
+	crt := &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+	a.certs[key] = crt
+
+	return crt, nil
+}
+
+func (a *acmeIssuer) HTTPHandler(next http.Handler) http.Handler {
+	const prefix = "/.well-known/acme-challenge/"
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.URL.Path, prefix)
+		if !found {
+			if next == nil {
+				http.NotFound(w, r)
+			} else {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+
+		resp, err := a.cfg.Store.GetChallenge(r.Context(), http01ChallengeKey(token))
+		if err != nil {
+			a.log.Warn("查询 ACME 验证应答出错", "token", token, "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if resp == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(resp))
+	})
+}
+
+func (a *acmeIssuer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		_ = a.Renew(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *acmeIssuer) Renew(ctx context.Context) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var errs []error
+	for _, name := range a.cfg.Names {
+		attrs := []any{"name", name}
+		// 每个域名签发前都续约一次，签发耗时较长时租约也不会过期。
+		// 没有拿到租约说明其它 broker 正在续期，签发的证书通过共享的存储生效。
+		won, err := a.cfg.Store.AcquireLease(ctx, acmeLease, a.holder, acmeLeaseTTL)
+		if err != nil {
+			attrs = append(attrs, "error", err)
+			a.log.Warn("获取 ACME 续期租约出错", attrs...)
+			return errors.Join(append(errs, err)...)
+		} else if !won {
+			a.log.Debug("其它节点持有 ACME 续期租约，跳过续期", attrs...)
+			return errors.Join(errs...)
+		}
+
+		need, err := a.needRenew(ctx, name)
+		if err != nil {
+			attrs = append(attrs, "error", err)
+			a.log.Warn("查询 ACME 证书出错", attrs...)
+			errs = append(errs, err)
+			continue
+		} else if !need {
+			continue
+		}
+
+		a.log.Info("开始签发 ACME 证书", attrs...)
+		leaf, err := a.obtain(ctx, name)
+		if err != nil {
+			attrs = append(attrs, "error", err)
+			a.log.Warn("签发 ACME 证书出错", attrs...)
+			errs = append(errs, fmt.Errorf("tlscert: acme %s: %w", name, err))
+			continue
+		}
+		attrs = append(attrs, "not_after", leaf.NotAfter)
+		a.log.Info("签发 ACME 证书完成", attrs...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// needRenew 证书不存在、不包含该域名或者即将过期时需要续期。
+func (a *acmeIssuer) needRenew(ctx context.Context, name string) (bool, error) {
+	crt, err := a.cfg.Store.LoadCertificate(ctx, acmeRecordName(name))
+	if err != nil || crt == nil {
+		return err == nil, err
+	}
+	leaf := crt.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(crt.Certificate[0]); err != nil {
+			return true, nil
+		}
+	}
+	if leaf.VerifyHostname(name) != nil {
+		return true, nil
+	}
+
+	before := a.cfg.RenewBefore
+	if before <= 0 {
+		before = leaf.NotAfter.Sub(leaf.NotBefore) / 3
+	}
+
+	return time.Until(leaf.NotAfter) < before, nil
+}
+
+// obtain 完成 RFC 8555 第 7.4 节的签发流程并保存证书。
+func (a *acmeIssuer) obtain(parent context.Context, name string) (*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(parent, 5*time.Minute)
+	defer cancel()
+
+	client, err := a.account(ctx)
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(name))
+	if err != nil {
+		return nil, err
+	}
+	for _, zurl := range order.AuthzURLs {
+		if err = a.authorize(ctx, client, name, zurl); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, err
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	req := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: name},
+		DNSNames: []string{name},
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, req, priv)
+	if err != nil {
+		return nil, err
+	}
+	ders, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(ders) == 0 {
+		return nil, errors.New("empty certificate chain")
+	}
+	leaf, err := x509.ParseCertificate(ders[0])
+	if err != nil {
+		return nil, err
+	}
+	if err = leaf.VerifyHostname(name); err != nil {
+		return nil, err
+	}
+
+	var chain []byte
+	for _, der := range ders {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	privBytes, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes})
+	if err = a.cfg.Store.SaveCertificate(ctx, acmeRecordName(name), chain, keyPEM); err != nil {
+		return nil, err
+	}
+
+	return leaf, nil
+}
+
+// account 懒注册 ACME 账户，账户已存在时直接使用。
+func (a *acmeIssuer) account(ctx context.Context) (*acme.Client, error) {
+	if a.client != nil {
+		return a.client, nil
+	}
+
+	key := a.cfg.AccountKey
+	if key == nil {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key = priv
+	}
+	client := &acme.Client{
+		Key:          key,
+		HTTPClient:   a.cfg.HTTPClient,
+		DirectoryURL: a.cfg.DirectoryURL,
+		UserAgent:    "aegis-control",
+	}
+	acct := new(acme.Account)
+	if email := a.cfg.Email; email != "" {
+		acct.Contact = []string{"mailto:" + email}
+	}
+	if _, err := client.Register(ctx, acct, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, err
+	}
+	// 保存生成的密钥，进程内的续期复用同一个账户。
+	a.cfg.AccountKey = key
+	a.client = client
+
+	return client, nil
+}
+
+func (a *acmeIssuer) authorize(ctx context.Context, client *acme.Client, name, zurl string) error {
+	authz, err := client.GetAuthorization(ctx, zurl)
+	if err != nil {
+		return err
+	}
+	switch authz.Status {
+	case acme.StatusValid:
+		return nil
+	case acme.StatusPending:
+	default:
+		return fmt.Errorf("authorization %s is %s", name, authz.Status)
+	}
+
+	// 每个订单只有一个域名，兼容不返回 identifier 的服务端。
+	domain := authz.Identifier.Value
+	if domain == "" {
+		domain = name
+	}
+
+	var chal *acme.Challenge
+	for _, typ := range a.cfg.Challenges {
+		idx := slices.IndexFunc(authz.Challenges, func(c *acme.Challenge) bool { return c.Type == typ })
+		if idx >= 0 {
+			chal = authz.Challenges[idx]
+			break
+		}
+	}
+	if chal == nil {
+		return errors.New("no supported challenge for " + domain)
+	}
+
+	cleanup, err := a.fulfill(ctx, client, domain, chal)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if _, err = client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+
+	return err
+}
+
+// fulfill 将验证的应答保存到共享的存储中，验证结束后调用返回的 cleanup 清理。
+func (a *acmeIssuer) fulfill(ctx context.Context, client *acme.Client, domain string, chal *acme.Challenge) (func(), error) {
+	var key string
+	switch chal.Type {
+	case ChallengeHTTP01:
+		key = http01ChallengeKey(chal.Token)
+	case ChallengeTLSALPN01:
+		key = tlsALPNChallengeKey(domain)
+	default:
+		return nil, errors.New("unsupported challenge " + chal.Type)
+	}
+
+	// HTTP-01 的应答就是 key authorization（RFC 8555 第 8.1 节）。
+	keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+	if err != nil {
+		return nil, err
+	}
+	if err = a.cfg.Store.PutChallenge(ctx, key, keyAuth, acmeChallengeTTL); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		// ctx 可能已经取消，清理失败时由存储的过期时间兜底。
+		cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if exx := a.cfg.Store.DeleteChallenge(cctx, key); exx != nil {
+			a.log.Warn("删除 ACME 验证应答出错", "key", key, "error", exx)
+		}
+	}, nil
+}
+
+func acmeRecordName(domain string) string {
+	return "acme:" + domain
+}
+
+func http01ChallengeKey(token string) string {
+	return ChallengeHTTP01 + ":" + token
+}
+
+func tlsALPNChallengeKey(domain string) string {
+	return ChallengeTLSALPN01 + ":" + domain
+}
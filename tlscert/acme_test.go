@@ -0,0 +1,373 @@
+package tlscert
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// memACMEStore 内存中的 ACMEStore，模拟多个 broker 共享的数据库。
+type memACMEStore struct {
+	mutex      sync.Mutex
+	certs      map[string]*tls.Certificate
+	challenges map[string]string
+	leases     map[string]memLease
+	loads      int
+}
+
+type memLease struct {
+	holder    string
+	expiresAt time.Time
+}
+
+func newMemACMEStore() *memACMEStore {
+	return &memACMEStore{
+		certs:      make(map[string]*tls.Certificate),
+		challenges: make(map[string]string),
+		leases:     make(map[string]memLease),
+	}
+}
+
+func (m *memACMEStore) LoadCertificate(_ context.Context, name string) (*tls.Certificate, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.loads++
+
+	return m.certs[name], nil
+}
+
+func (m *memACMEStore) SaveCertificate(_ context.Context, name string, chain, key []byte) error {
+	crt, err := tls.X509KeyPair(chain, key)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	m.certs[name] = &crt
+	m.mutex.Unlock()
+
+	return nil
+}
+
+func (m *memACMEStore) PutChallenge(_ context.Context, key, value string, _ time.Duration) error {
+	m.mutex.Lock()
+	m.challenges[key] = value
+	m.mutex.Unlock()
+
+	return nil
+}
+
+func (m *memACMEStore) GetChallenge(_ context.Context, key string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.challenges[key], nil
+}
+
+func (m *memACMEStore) DeleteChallenge(_ context.Context, key string) error {
+	m.mutex.Lock()
+	delete(m.challenges, key)
+	m.mutex.Unlock()
+
+	return nil
+}
+
+func (m *memACMEStore) AcquireLease(_ context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if l, ok := m.leases[name]; ok && l.holder != holder && l.expiresAt.After(now) {
+		return false, nil
+	}
+	m.leases[name] = memLease{holder: holder, expiresAt: now.Add(ttl)}
+
+	return true, nil
+}
+
+func newTestIssuer(t *testing.T, store ACMEStore, names ...string) *acmeIssuer {
+	t.Helper()
+	iss, err := NewACMEIssuer(ACMEConfig{Names: names, Store: store}, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return iss.(*acmeIssuer)
+}
+
+// TestACMEChallengeShared 一个 broker 准备的应答，另一个 broker 也能应答验证请求。
+func TestACMEChallengeShared(t *testing.T) {
+	const domain = "broker.example.com"
+	store := newMemACMEStore()
+	issuer := newTestIssuer(t, store, domain)
+	other := newTestIssuer(t, store, domain)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	client := &acme.Client{Key: key}
+	ctx := context.Background()
+
+	t.Run("http-01", func(t *testing.T) {
+		chal := &acme.Challenge{Type: ChallengeHTTP01, Token: "http-token"}
+		cleanup, err := issuer.fulfill(ctx, client, domain, chal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := client.HTTP01ChallengeResponse(chal.Token)
+
+		srv := httptest.NewServer(other.HTTPHandler(nil))
+		defer srv.Close()
+		url := srv.URL + client.HTTP01ChallengePath(chal.Token)
+		if code, body := httpGet(t, url); code != http.StatusOK || body != want {
+			t.Fatalf("GET = %d %q, want 200 %q", code, body, want)
+		}
+
+		cleanup()
+		if code, _ := httpGet(t, url); code != http.StatusNotFound {
+			t.Fatalf("GET after cleanup = %d, want 404", code)
+		}
+	})
+
+	t.Run("tls-alpn-01", func(t *testing.T) {
+		chal := &acme.Challenge{Type: ChallengeTLSALPN01, Token: "alpn-token"}
+		cleanup, err := issuer.fulfill(ctx, client, domain, chal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cleanup()
+
+		leaf, err := alpnHandshake(other, domain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = leaf.VerifyHostname(domain); err != nil {
+			t.Fatal(err)
+		}
+		keyAuth, _ := client.HTTP01ChallengeResponse(chal.Token)
+		sum := sha256.Sum256([]byte(keyAuth))
+		var found bool
+		for _, ext := range leaf.Extensions {
+			if !ext.Id.Equal(idPeACMEIdentifier) {
+				continue
+			}
+			var got []byte
+			if _, err = asn1.Unmarshal(ext.Value, &got); err != nil {
+				t.Fatal(err)
+			}
+			found = ext.Critical && bytes.Equal(got, sum[:])
+		}
+		if !found {
+			t.Fatal("acmeIdentifier extension missing or mismatched")
+		}
+
+		// 没有准备应答的域名握手失败。
+		if _, err = alpnHandshake(other, "unknown.example.com"); err == nil {
+			t.Fatal("handshake for unknown domain succeeded")
+		}
+	})
+}
+
+// TestACMELease 只有持有租约的签发器执行续期。
+func TestACMELease(t *testing.T) {
+	const domain = "broker.example.com"
+	store := newMemACMEStore()
+	store.certs[acmeRecordName(domain)] = validCertificate(t, domain)
+
+	first := newTestIssuer(t, store, domain)
+	second := newTestIssuer(t, store, domain)
+	ctx := context.Background()
+
+	if err := first.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if store.loads != 1 {
+		t.Fatalf("loads = %d, want 1", store.loads)
+	}
+	if err := second.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if store.loads != 1 {
+		t.Fatalf("second issuer checked certificates without the lease, loads = %d", store.loads)
+	}
+
+	// 租约过期后其它签发器接管。
+	store.leases[acmeLease] = memLease{holder: first.holder, expiresAt: time.Now().Add(-time.Second)}
+	if err := second.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if store.loads != 2 {
+		t.Fatalf("loads = %d after lease expired, want 2", store.loads)
+	}
+	if ok, _ := store.AcquireLease(ctx, acmeLease, first.holder, time.Minute); ok {
+		t.Fatal("first issuer reacquired a lease held by second")
+	}
+}
+
+// TestACMEPebble 使用 Pebble 完成真实的签发流程，两个签发器共享存储，
+// 由一个签发，另一个应答 HTTP-01 验证。需要先启动 Pebble：
+//
+//	PEBBLE_VA_NOSLEEP=1 pebble -config test/config/pebble-config.json -dnsserver 127.0.0.1:8053
+//
+// 环境变量：
+//
+//	PEBBLE_DIRECTORY  Pebble 的目录地址，例如 https://127.0.0.1:14000/dir，未设置时跳过。
+//	PEBBLE_DOMAIN     签发的域名，需要解析到本机，默认 localhost.localdomain。
+//	PEBBLE_HTTP_ADDR  应答 HTTP-01 验证的监听地址，默认 :5002（Pebble 的 httpPort）。
+func TestACMEPebble(t *testing.T) {
+	dir := os.Getenv("PEBBLE_DIRECTORY")
+	if dir == "" {
+		t.Skip("PEBBLE_DIRECTORY is not set")
+	}
+	domain := envOr("PEBBLE_DOMAIN", "localhost.localdomain")
+	addr := envOr("PEBBLE_HTTP_ADDR", ":5002")
+
+	store := newMemACMEStore()
+	// Pebble 的 HTTPS 使用自签证书，测试中不校验。
+	hc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	cfg := ACMEConfig{
+		DirectoryURL: dir,
+		HTTPClient:   hc,
+		Names:        []string{domain},
+		Challenges:   []string{ChallengeHTTP01},
+		Store:        store,
+	}
+	log := slog.New(slog.DiscardHandler)
+	issuer, err := NewACMEIssuer(cfg, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, err := NewACMEIssuer(cfg, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: responder.HTTPHandler(nil)}
+	go srv.Serve(lis)
+	//goland:noinspection GoUnhandledErrorResult
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err = issuer.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	crt, _ := store.LoadCertificate(ctx, acmeRecordName(domain))
+	if crt == nil {
+		t.Fatal("certificate was not saved")
+	}
+	leaf, err := x509.ParseCertificate(crt.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = leaf.VerifyHostname(domain); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.challenges) != 0 {
+		t.Fatalf("challenges left in store: %d", len(store.challenges))
+	}
+
+	// 证书有效时无需续期，也不会再次签发。
+	if err = responder.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := store.LoadCertificate(ctx, acmeRecordName(domain)); again != crt {
+		t.Fatal("certificate was reissued")
+	}
+}
+
+func httpGet(t *testing.T, url string) (int, string) {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+
+	return res.StatusCode, string(body)
+}
+
+// alpnHandshake 模拟 ACME 服务发起 TLS-ALPN-01 验证握手，返回服务端的证书。
+func alpnHandshake(c ACMEChallenger, domain string) (*x509.Certificate, error) {
+	cli, srv := net.Pipe()
+	//goland:noinspection GoUnhandledErrorResult
+	defer cli.Close()
+
+	server := tls.Server(srv, &tls.Config{
+		NextProtos:     []string{ACMEALPN},
+		GetCertificate: c.GetChallengeCertificate,
+	})
+	go func() {
+		_ = server.Handshake()
+		_ = server.Close()
+	}()
+
+	client := tls.Client(cli, &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{ACMEALPN},
+		InsecureSkipVerify: true,
+	})
+	if err := client.Handshake(); err != nil {
+		return nil, err
+	}
+
+	return client.ConnectionState().PeerCertificates[0], nil
+}
+
+func validCertificate(t *testing.T, domain string) *tls.Certificate {
+	t.Helper()
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(priv)
+	crt, err := tls.X509KeyPair(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &crt
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
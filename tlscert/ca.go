@@ -0,0 +1,540 @@
+package tlscert
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+)
+
+// CAStore 内部 CA 根证书的存储，repository.CertificateAuthority 实现了该接口。
+type CAStore interface {
+	// LoadRoots 获取所有根证书，包括已过期和已吊销的，顺序不限。
+	LoadRoots(ctx context.Context) (model.CertificateAuthorities, error)
+
+	// InsertRoot 保存新生成的根证书，相同 Generation 的根证书已存在时返回 false，
+	// 说明其它 broker 已经生成了这一代根证书，必须是原子操作。
+	InsertRoot(ctx context.Context, root *model.CertificateAuthority) (bool, error)
+
+	// RevokeRoots 吊销 Generation 小于 generation 的所有根证书。
+	RevokeRoots(ctx context.Context, generation int64) error
+}
+
+// CAConfig 内部 CA 配置。
+type CAConfig struct {
+	// Store 根证书存储，必须设置。
+	Store CAStore
+
+	// RootValidity 根证书的有效期，小于等于 0 时默认为 10 年。
+	RootValidity time.Duration
+
+	// RotateBefore 当前根证书到期前多久生成新的根证书，小于等于 0 时默认为 1 年。
+	// 轮换后旧的根证书在过期前仍然保留在证书包中，需要大于 LeafValidity，
+	// 客户端也要在此期间更新固定的证书包。
+	RotateBefore time.Duration
+
+	// RotateGrace 自动轮换生成的根证书先只出现在证书包中，经过 RotateGrace 后才开始签发证书，
+	// 给其它 broker 和客户端留出更新证书包的时间，小于等于 0 时默认为 7 天。
+	// Rotate 生成的根证书立即生效，不受此限制。
+	RotateGrace time.Duration
+
+	// LeafValidity 节点证书的有效期，小于等于 0 时默认为 24h。
+	LeafValidity time.Duration
+
+	// KeyEncryptionKey 加密根证书私钥的 32 字节 AES-256 密钥，私钥使用 AES-GCM 加密后再写入 CAStore。
+	// 密钥由调用方提供，不能与根证书保存在同一个数据库中，例如从配置文件或者密钥管理服务读取，
+	// 所有 broker 必须使用相同的密钥。为空时 NewCA 返回错误，除非设置了 PlaintextKey。
+	KeyEncryptionKey []byte
+
+	// PlaintextKey 为 true 并且没有设置 KeyEncryptionKey 时以明文 PEM 保存私钥，
+	// 仅用于测试或者存储本身已经加密的环境。
+	PlaintextKey bool
+}
+
+// IssueRequest 节点证书签发请求。
+type IssueRequest struct {
+	// Identity 节点身份，以 URI SAN 的形式写入证书，见 Identity.URI。
+	Identity Identity
+
+	// Hosts 节点的主机名或 IP 地址，写入 DNS 和 IP SAN，例如 broker 对外暴露的 hub 地址，
+	// 可以带端口，端口会被忽略。
+	Hosts []string
+
+	// PublicKey 节点自己生成的公钥，私钥不离开节点。为 nil 时由 CA 生成密钥对，
+	// 返回的 tls.Certificate 中包含私钥。
+	PublicKey crypto.PublicKey
+}
+
+// CA 内部证书颁发机构，为 broker 和 agent 签发短期的 mTLS 证书。
+//
+// 根证书保存在 CAStore 中，多个 broker 共享同一组根证书。签发使用最新的已生效根证书，
+// 证书包包含所有未过期、未吊销的根证书，所以轮换期间新旧根证书签发的证书都能通过校验。
+type CA interface {
+	// Issue 使用最新的已生效根证书签发节点证书，证书链只有节点证书本身，根证书由对端固定。
+	Issue(ctx context.Context, req IssueRequest) (*tls.Certificate, error)
+
+	// Bundle 所有未过期、未吊销根证书的 PEM 证书包，供客户端固定信任的 CA。尚未加载时返回 nil。
+	Bundle() []byte
+
+	// CertPool 所有未过期、未吊销的根证书，可以作为 quick.ClientAuthConfig.ClientCAs。尚未加载时返回 nil。
+	CertPool() *x509.CertPool
+
+	// Reload 从存储加载根证书，没有可用的根证书或者当前根证书即将到期时生成新的根证书。
+	// 多个 broker 同时生成时只有一个能保存成功，其余的重新加载并使用它生成的根证书。
+	// 加载失败时保留旧的根证书并返回错误。
+	Reload(ctx context.Context) error
+
+	// Rotate 立即生成新的根证书，之后的证书都由新的根证书签发，用于根证书泄露等紧急情况。
+	// 旧的根证书在存储中标记为吊销并移出证书包，由旧根证书签发的证书不再被信任，
+	// 其它 broker 在 Reload 后同样不再信任。
+	Rotate(ctx context.Context) error
+
+	// Refresh 按照配置自动 Reload，其它 broker 轮换的根证书通过 Reload 生效。
+	Refresh(ctx context.Context, cfg RefreshConfig) error
+}
+
+func NewCA(cfg CAConfig, log *slog.Logger) (CA, error) {
+	if cfg.Store == nil {
+		return nil, errors.New("tlscert: ca store is required")
+	}
+	if cfg.RootValidity <= 0 {
+		cfg.RootValidity = 10 * 365 * 24 * time.Hour
+	}
+	if cfg.RotateBefore <= 0 {
+		cfg.RotateBefore = 365 * 24 * time.Hour
+	}
+	if cfg.RotateGrace <= 0 {
+		cfg.RotateGrace = 7 * 24 * time.Hour
+	}
+	if cfg.LeafValidity <= 0 {
+		cfg.LeafValidity = 24 * time.Hour
+	}
+	if cfg.RotateBefore >= cfg.RootValidity || cfg.RotateGrace+cfg.LeafValidity >= cfg.RotateBefore {
+		return nil, errors.New("tlscert: ca validity must satisfy RotateGrace + LeafValidity < RotateBefore < RootValidity")
+	}
+
+	ca := &certificateAuthority{cfg: cfg, log: log}
+	if kek := cfg.KeyEncryptionKey; len(kek) != 0 {
+		if len(kek) != 32 {
+			return nil, errors.New("tlscert: ca key encryption key must be 32 bytes")
+		}
+		block, err := aes.NewCipher(kek)
+		if err != nil {
+			return nil, err
+		}
+		if ca.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	} else if !cfg.PlaintextKey {
+		return nil, errors.New("tlscert: ca key encryption key is required")
+	}
+
+	return ca, nil
+}
+
+type certificateAuthority struct {
+	cfg   CAConfig
+	aead  cipher.AEAD // 加密根证书私钥，PlaintextKey 时为 nil
+	log   *slog.Logger
+	mutex sync.Mutex
+	state atomic.Pointer[caState]
+}
+
+// rootBackdate 根证书 NotBefore 提前的时间，容忍节点之间的时钟偏差。
+const rootBackdate = time.Hour
+
+// caState 已加载的根证书，roots 按 NotBefore 倒序排列。
+type caState struct {
+	roots  []*caRoot
+	pool   *x509.CertPool
+	bundle []byte
+}
+
+// signer 最新的已生效根证书，都未生效时（例如首次生成）使用最早的根证书。
+func (s *caState) signer(grace time.Duration) *caRoot {
+	cutoff := time.Now().Add(-rootBackdate - grace)
+	for _, root := range s.roots {
+		if !root.cert.NotBefore.After(cutoff) {
+			return root
+		}
+	}
+	if n := len(s.roots); n != 0 {
+		return s.roots[n-1]
+	}
+
+	return nil
+}
+
+type caRoot struct {
+	generation int64
+	cert       *x509.Certificate
+	key        crypto.Signer
+}
+
+func (ca *certificateAuthority) Issue(ctx context.Context, req IssueRequest) (*tls.Certificate, error) {
+	if err := req.Identity.validate(); err != nil {
+		return nil, err
+	}
+	state := ca.state.Load()
+	if state == nil {
+		if err := ca.Reload(ctx); err != nil {
+			return nil, err
+		}
+		state = ca.state.Load()
+	}
+	root := state.signer(ca.cfg.RotateGrace)
+	if root == nil {
+		return nil, errors.New("tlscert: no usable ca root")
+	}
+
+	var priv crypto.Signer
+	pub := req.PublicKey
+	if pub == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		priv, pub = key, key.Public()
+	}
+	serialNumber, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(ca.cfg.LeafValidity)
+	if notAfter.After(root.cert.NotAfter) {
+		notAfter = root.cert.NotAfter
+	}
+	ident := req.Identity
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: ident.ID, OrganizationalUnit: []string{ident.Role}, Organization: []string{"aegis"}},
+		NotBefore:             now.Add(-5 * time.Minute), // 容忍节点之间的时钟偏差
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		URIs:                  []*url.URL{ident.URI()},
+	}
+	if ident.Role == RoleBroker {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+	for _, host := range req.Hosts {
+		if h, _, exx := net.SplitHostPort(host); exx == nil {
+			host = h
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host = strings.ToLower(host); host != "" && !slices.Contains(template.DNSNames, host) {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, root.cert, pub, root.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv, Leaf: leaf}, nil
+}
+
+func (ca *certificateAuthority) Bundle() []byte {
+	if state := ca.state.Load(); state != nil {
+		return state.bundle
+	}
+
+	return nil
+}
+
+func (ca *certificateAuthority) CertPool() *x509.CertPool {
+	if state := ca.state.Load(); state != nil {
+		return state.pool
+	}
+
+	return nil
+}
+
+func (ca *certificateAuthority) Reload(parent context.Context) error {
+	ctx, cancel := context.WithTimeout(parent, time.Minute)
+	defer cancel()
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	roots, generation, err := ca.loadRoots(ctx)
+	if err != nil {
+		ca.log.Warn("加载 CA 根证书出错，继续使用旧的根证书", "error", err)
+		return err
+	}
+	if len(roots) == 0 || time.Until(roots[0].cert.NotAfter) < ca.cfg.RotateBefore {
+		ca.log.Info("没有可用的 CA 根证书或者即将到期，开始生成新的根证书", "roots", len(roots))
+		if roots, err = ca.generateRoot(ctx, generation+1, false); err != nil {
+			ca.log.Warn("生成 CA 根证书出错", "error", err)
+			return err
+		}
+	}
+	ca.store(roots)
+
+	return nil
+}
+
+func (ca *certificateAuthority) Rotate(parent context.Context) error {
+	ctx, cancel := context.WithTimeout(parent, time.Minute)
+	defer cancel()
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+
+	_, generation, err := ca.loadRoots(ctx)
+	if err != nil {
+		return err
+	}
+	generation++
+	roots, err := ca.generateRoot(ctx, generation, true)
+	if err != nil {
+		ca.log.Warn("轮换 CA 根证书出错", "error", err)
+		return err
+	}
+
+	// 先吊销存储中的旧根证书再重新加载，吊销失败时本地也不再信任旧的根证书。
+	stale := func(r *caRoot) bool { return r.generation < generation }
+	if err = ca.cfg.Store.RevokeRoots(ctx, generation); err != nil {
+		ca.log.Warn("吊销旧的 CA 根证书出错", "error", err)
+		ca.store(slices.DeleteFunc(roots, stale))
+		return err
+	}
+	if reloaded, _, exx := ca.loadRoots(ctx); exx == nil && len(reloaded) != 0 {
+		roots = reloaded
+	} else {
+		roots = slices.DeleteFunc(roots, stale)
+	}
+	ca.log.Info("CA 根证书紧急轮换完成，旧的根证书已吊销", "generation", generation)
+	ca.store(roots)
+
+	return nil
+}
+
+func (ca *certificateAuthority) Refresh(ctx context.Context, cfg RefreshConfig) error {
+	return refresh(ctx, cfg, ca.log, ca.Reload)
+}
+
+// loadRoots 加载并解析未过期、未吊销的根证书，按照签发时间倒序排列，
+// 同时返回存储中最大的代数（包括已过期和已吊销的），下一代根证书在此基础上加一。
+func (ca *certificateAuthority) loadRoots(ctx context.Context) ([]*caRoot, int64, error) {
+	dats, err := ca.cfg.Store.LoadRoots(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var generation int64
+	now := time.Now()
+	roots := make([]*caRoot, 0, len(dats))
+	for _, dat := range dats {
+		generation = max(generation, dat.Generation)
+		if dat.Revoked || now.After(dat.NotAfter) {
+			continue
+		}
+		root, exx := parseCARoot(dat, ca.aead)
+		if exx != nil {
+			return nil, 0, exx
+		}
+		if now.After(root.cert.NotAfter) {
+			continue
+		}
+		roots = append(roots, root)
+	}
+	slices.SortFunc(roots, func(a, b *caRoot) int {
+		return b.cert.NotBefore.Compare(a.cert.NotBefore)
+	})
+
+	return roots, generation, nil
+}
+
+// generateRoot 生成第 generation 代根证书并保存，保存后重新加载所有根证书。
+// 其它 broker 已经保存了同一代根证书时，丢弃本地生成的，使用存储中的。
+// immediate 为 true 时把 NotBefore 再提前 RotateGrace，使其在所有 broker 上立即生效。
+func (ca *certificateAuthority) generateRoot(ctx context.Context, generation int64, immediate bool) ([]*caRoot, error) {
+	serialNumber, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notBefore := now.Add(-rootBackdate)
+	if immediate {
+		notBefore = notBefore.Add(-ca.cfg.RotateGrace)
+	}
+	template := &x509.Certificate{
+		IsCA:                  true,
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "aegis internal CA " + now.Format("20060102150405"), Organization: []string{"aegis"}},
+		NotBefore:             notBefore,
+		NotAfter:              now.Add(ca.cfg.RootValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	privBytes, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	keyBlock := &pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes}
+	if ca.aead != nil {
+		if keyBlock, err = sealCAKey(ca.aead, privBytes, der); err != nil {
+			return nil, err
+		}
+	}
+
+	sum := sha256.Sum256(der)
+	dat := &model.CertificateAuthority{
+		Generation:        generation,
+		CommonName:        cert.Subject.CommonName,
+		Certificate:       string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKey:        string(pem.EncodeToMemory(keyBlock)),
+		CertificateSHA256: hex.EncodeToString(sum[:]),
+		NotBefore:         cert.NotBefore,
+		NotAfter:          cert.NotAfter,
+		CreatedAt:         now,
+	}
+	inserted, err := ca.cfg.Store.InsertRoot(ctx, dat)
+	if err != nil {
+		return nil, err
+	}
+	attrs := []any{"generation", generation, "subject", cert.Subject.CommonName, "not_after", cert.NotAfter}
+	if inserted {
+		ca.log.Info("CA 根证书生成完毕", attrs...)
+	} else {
+		ca.log.Info("其它节点已经生成了这一代 CA 根证书，使用存储中的根证书", attrs...)
+	}
+
+	roots, _, err := ca.loadRoots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("tlscert: no ca root after generation")
+	}
+
+	return roots, nil
+}
+
+// caKeyBlockType 加密后的根证书私钥的 PEM 类型，内容为 nonce 和 AES-GCM 密文。
+const caKeyBlockType = "AEGIS ENCRYPTED EC PRIVATE KEY"
+
+// sealCAKey 加密根证书私钥，以证书的 SHA-256 作为附加数据，私钥无法被挪到其它证书下使用。
+func sealCAKey(aead cipher.AEAD, keyDER, certDER []byte) (*pem.Block, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(certDER)
+
+	return &pem.Block{Type: caKeyBlockType, Bytes: aead.Seal(nonce, nonce, keyDER, sum[:])}, nil
+}
+
+// openCAKey 解密根证书私钥，返回明文 PEM。aead 为 nil 时只接受明文私钥，否则只接受加密的私钥。
+func openCAKey(aead cipher.AEAD, dat *model.CertificateAuthority) ([]byte, error) {
+	gen := strconv.FormatInt(dat.Generation, 10)
+	block, _ := pem.Decode([]byte(dat.PrivateKey))
+	if block == nil {
+		return nil, errors.New("tlscert: invalid private key of ca root " + gen)
+	}
+	if block.Type != caKeyBlockType {
+		if aead != nil {
+			return nil, errors.New("tlscert: private key of ca root " + gen + " is not encrypted")
+		}
+		return []byte(dat.PrivateKey), nil
+	}
+	if aead == nil {
+		return nil, errors.New("tlscert: private key of ca root " + gen + " is encrypted but no key encryption key is configured")
+	}
+
+	certBlock, _ := pem.Decode([]byte(dat.Certificate))
+	if certBlock == nil || len(block.Bytes) < aead.NonceSize() {
+		return nil, errors.New("tlscert: invalid ca root " + gen)
+	}
+	sum := sha256.Sum256(certBlock.Bytes)
+	n := aead.NonceSize()
+	der, err := aead.Open(nil, block.Bytes[:n], block.Bytes[n:], sum[:])
+	if err != nil {
+		return nil, errors.New("tlscert: decrypt private key of ca root " + gen + ": wrong key encryption key or tampered data")
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// parseCARoot 解析存储中的根证书，私钥的加密方式见 openCAKey。
+func parseCARoot(dat *model.CertificateAuthority, aead cipher.AEAD) (*caRoot, error) {
+	keyPEM, err := openCAKey(aead, dat)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := tls.X509KeyPair([]byte(dat.Certificate), keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := kp.PrivateKey.(crypto.Signer)
+	if !ok || !kp.Leaf.IsCA {
+		return nil, errors.New("tlscert: invalid ca root " + kp.Leaf.Subject.CommonName)
+	}
+
+	return &caRoot{generation: dat.Generation, cert: kp.Leaf, key: key}, nil
+}
+
+func (ca *certificateAuthority) store(roots []*caRoot) {
+	pool := x509.NewCertPool()
+	var bundle []byte
+	for _, root := range roots {
+		pool.AddCert(root.cert)
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw})...)
+	}
+	ca.state.Store(&caState{roots: roots, pool: pool, bundle: bundle})
+	attrs := []any{"roots", len(roots)}
+	if len(roots) != 0 {
+		attrs = append(attrs, "newest", roots[0].cert.Subject.CommonName)
+	}
+	ca.log.Info("CA 根证书加载完成", attrs...)
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,399 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xmx/aegis-control/datalayer/model"
+)
+
+// memCAStore 内存中的 CAStore，与数据库一样保证 Generation 唯一。
+type memCAStore struct {
+	mutex sync.Mutex
+	roots model.CertificateAuthorities
+}
+
+func (m *memCAStore) LoadRoots(context.Context) (model.CertificateAuthorities, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	dats := make(model.CertificateAuthorities, 0, len(m.roots))
+	for _, root := range m.roots {
+		dat := *root
+		dats = append(dats, &dat)
+	}
+
+	return dats, nil
+}
+
+func (m *memCAStore) InsertRoot(_ context.Context, root *model.CertificateAuthority) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, dat := range m.roots {
+		if dat.Generation == root.Generation {
+			return false, nil
+		}
+	}
+	dat := *root
+	m.roots = append(m.roots, &dat)
+
+	return true, nil
+}
+
+func (m *memCAStore) RevokeRoots(_ context.Context, generation int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, dat := range m.roots {
+		if dat.Generation < generation && !dat.Revoked {
+			dat.Revoked, dat.RevokedAt = true, time.Now()
+		}
+	}
+
+	return nil
+}
+
+func (m *memCAStore) revoked() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var n int
+	for _, dat := range m.roots {
+		if dat.Revoked {
+			n++
+		}
+	}
+
+	return n
+}
+
+// testKEK 测试使用的根证书私钥加密密钥。
+var testKEK = []byte("0123456789abcdef0123456789abcdef")
+
+func testAEAD(t *testing.T) cipher.AEAD {
+	t.Helper()
+	block, err := aes.NewCipher(testKEK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return aead
+}
+
+func newTestCA(t *testing.T, store CAStore, cfg CAConfig) CA {
+	t.Helper()
+	cfg.Store = store
+	if cfg.KeyEncryptionKey == nil && !cfg.PlaintextKey {
+		cfg.KeyEncryptionKey = testKEK
+	}
+	ca, err := NewCA(cfg, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ca
+}
+
+func TestCAIssue(t *testing.T) {
+	store := new(memCAStore)
+	ca := newTestCA(t, store, CAConfig{LeafValidity: time.Hour})
+	ctx := context.Background()
+
+	ident := Identity{Role: RoleBroker, ID: "b1"}
+	crt, err := ca.Issue(ctx, IssueRequest{
+		Identity: ident,
+		Hosts:    []string{"Broker.Example.com:443", "10.0.0.1", "broker.example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.roots) != 1 || store.roots[0].Generation != 1 {
+		t.Fatalf("roots = %d, want one root of generation 1", len(store.roots))
+	}
+	if crt.PrivateKey == nil {
+		t.Fatal("private key missing when PublicKey is nil")
+	}
+
+	leaf := crt.Leaf
+	opts := x509.VerifyOptions{Roots: ca.CertPool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if _, err = leaf.Verify(opts); err != nil {
+		t.Fatal(err)
+	}
+	opts.KeyUsages, opts.DNSName = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, "broker.example.com"
+	if _, err = leaf.Verify(opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(leaf.DNSNames) != 1 || len(leaf.IPAddresses) != 1 || !leaf.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("SAN = %v %v", leaf.DNSNames, leaf.IPAddresses)
+	}
+	if got, exx := ParseIdentity(leaf); exx != nil || got != ident {
+		t.Fatalf("identity = %v, %v", got, exx)
+	}
+	if d := time.Until(leaf.NotAfter); d > time.Hour {
+		t.Fatalf("leaf valid for %s, want <= 1h", d)
+	}
+
+	// agent 证书不能用作服务端证书，节点自带公钥时不返回私钥。
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	crt, err = ca.Issue(ctx, IssueRequest{Identity: Identity{Role: RoleAgent, ID: "a1"}, PublicKey: key.Public()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crt.PrivateKey != nil {
+		t.Fatal("private key returned for external public key")
+	}
+	opts.DNSName = ""
+	if _, err = crt.Leaf.Verify(opts); err == nil {
+		t.Fatal("agent certificate verified for server auth")
+	}
+
+	if _, err = ca.Issue(ctx, IssueRequest{Identity: Identity{Role: "admin", ID: "x"}}); err == nil {
+		t.Fatal("issued certificate for unknown role")
+	}
+}
+
+// TestCAReloadConcurrent 多个 broker 同时首次启动时只生成一个根证书。
+func TestCAReloadConcurrent(t *testing.T) {
+	store := new(memCAStore)
+	cas := make([]CA, 8)
+	for i := range cas {
+		cas[i] = newTestCA(t, store, CAConfig{})
+	}
+
+	var wg sync.WaitGroup
+	for _, ca := range cas {
+		wg.Go(func() {
+			if err := ca.Reload(context.Background()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if len(store.roots) != 1 {
+		t.Fatalf("roots = %d, want 1", len(store.roots))
+	}
+	for _, ca := range cas {
+		if string(ca.Bundle()) != store.roots[0].Certificate {
+			t.Fatal("broker is not using the stored root")
+		}
+	}
+}
+
+// TestCARotationOverlap 自动轮换期间新旧根证书同时被信任，紧急轮换后旧根证书立即不再被信任。
+func TestCARotationOverlap(t *testing.T) {
+	now := time.Now()
+	store := new(memCAStore)
+	store.roots = append(store.roots, testRootDoc(t, 1, now.Add(-48*time.Hour), now.Add(10*time.Hour)))
+	cfg := CAConfig{
+		RootValidity: 30 * 24 * time.Hour,
+		RotateBefore: 24 * time.Hour,
+		RotateGrace:  time.Hour,
+		LeafValidity: time.Hour,
+	}
+	ca := newTestCA(t, store, cfg)
+	peer := newTestCA(t, store, cfg)
+	ctx := context.Background()
+	ident := Identity{Role: RoleAgent, ID: "a1"}
+
+	// 第 1 代根证书即将到期，Reload 生成第 2 代，两个 broker 只生成一次。
+	if err := ca.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.roots) != 2 {
+		t.Fatalf("roots = %d, want 2", len(store.roots))
+	}
+	if n := countCerts(ca.Bundle()); n != 2 {
+		t.Fatalf("bundle has %d roots during overlap, want 2", n)
+	}
+
+	// 宽限期内仍然由第 1 代签发，签发的证书在所有 broker 上都能通过校验。
+	old, err := ca.Issue(ctx, IssueRequest{Identity: ident})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen1 := parseRootDoc(t, store.roots[0])
+	if err = old.Leaf.CheckSignatureFrom(gen1); err != nil {
+		t.Fatalf("leaf not signed by generation 1 during grace: %v", err)
+	}
+	verify := func(ca CA, crt *x509.Certificate) error {
+		opts := x509.VerifyOptions{Roots: ca.CertPool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+		_, exx := crt.Verify(opts)
+		return exx
+	}
+	if err = verify(peer, old.Leaf); err != nil {
+		t.Fatal(err)
+	}
+
+	// 紧急轮换：旧根证书在存储中吊销，并立即移出证书包。
+	if err = ca.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.revoked(); n != 2 {
+		t.Fatalf("revoked = %d, want 2", n)
+	}
+	if n := countCerts(ca.Bundle()); n != 1 {
+		t.Fatalf("bundle has %d roots after rotation, want 1", n)
+	}
+	if err = verify(ca, old.Leaf); err == nil {
+		t.Fatal("certificate from revoked root still trusted")
+	}
+	fresh, err := ca.Issue(ctx, IssueRequest{Identity: ident})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verify(ca, fresh.Leaf); err != nil {
+		t.Fatal(err)
+	}
+
+	// 其它 broker Reload 后同样不再信任旧根证书，并且不会再生成新的根证书。
+	if err = peer.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = verify(peer, old.Leaf); err == nil {
+		t.Fatal("peer still trusts revoked root after reload")
+	}
+	if err = verify(peer, fresh.Leaf); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.roots) != 3 {
+		t.Fatalf("roots = %d, want 3", len(store.roots))
+	}
+}
+
+// TestCAKeyEncryption 根证书私钥加密后保存，密钥不匹配或者加密方式不一致时拒绝加载。
+func TestCAKeyEncryption(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewCA(CAConfig{Store: new(memCAStore)}, slog.New(slog.DiscardHandler)); err == nil {
+		t.Fatal("NewCA() without key encryption key succeeded")
+	}
+	if _, err := NewCA(CAConfig{Store: new(memCAStore), KeyEncryptionKey: testKEK[:16]}, slog.New(slog.DiscardHandler)); err == nil {
+		t.Fatal("NewCA() accepted a 16 byte key encryption key")
+	}
+
+	store := new(memCAStore)
+	ca := newTestCA(t, store, CAConfig{})
+	if err := ca.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	dat := store.roots[0]
+	if block, _ := pem.Decode([]byte(dat.PrivateKey)); block == nil || block.Type != caKeyBlockType {
+		t.Fatalf("root private key stored as %q, want %q", dat.PrivateKey[:min(len(dat.PrivateKey), 40)], caKeyBlockType)
+	}
+
+	// 其它 broker 使用相同的密钥可以加载。
+	if err := newTestCA(t, store, CAConfig{}).Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	wrongKey := []byte(strings.Repeat("x", 32))
+	cases := []struct {
+		name string
+		cfg  CAConfig
+	}{
+		{name: "wrong key", cfg: CAConfig{KeyEncryptionKey: wrongKey}},
+		{name: "plaintext", cfg: CAConfig{PlaintextKey: true}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := newTestCA(t, store, tc.cfg).Reload(ctx); err == nil {
+				t.Fatal("Reload() succeeded")
+			}
+			if len(store.roots) != 1 {
+				t.Fatalf("roots = %d, a broker that cannot decrypt must not generate new roots", len(store.roots))
+			}
+		})
+	}
+
+	// 私钥与证书绑定，不能挪到其它证书下使用。
+	other := testRootDoc(t, 2, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	moved := *dat
+	moved.Certificate = other.Certificate
+	if _, err := parseCARoot(&moved, testAEAD(t)); err == nil {
+		t.Fatal("private key decrypted under a different certificate")
+	}
+
+	// 明文存储的私钥在配置了密钥时被拒绝。
+	plain := new(memCAStore)
+	if err := newTestCA(t, plain, CAConfig{PlaintextKey: true}).Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(plain.roots[0].PrivateKey, "BEGIN EC PRIVATE KEY") {
+		t.Fatal("PlaintextKey did not store a plaintext key")
+	}
+	if err := newTestCA(t, plain, CAConfig{}).Reload(ctx); err == nil {
+		t.Fatal("plaintext root key accepted with a key encryption key configured")
+	}
+}
+
+func testRootDoc(t *testing.T, generation int64, notBefore, notAfter time.Time) *model.CertificateAuthority {
+	t.Helper()
+	priv, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	template := &x509.Certificate{
+		IsCA:                  true,
+		SerialNumber:          big.NewInt(generation),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(priv)
+	keyBlock, err := sealCAKey(testAEAD(t), keyDER, der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &model.CertificateAuthority{
+		Generation:  generation,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKey:  string(pem.EncodeToMemory(keyBlock)),
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+	}
+}
+
+func parseRootDoc(t *testing.T, dat *model.CertificateAuthority) *x509.Certificate {
+	t.Helper()
+	root, err := parseCARoot(dat, testAEAD(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return root.cert
+}
+
+func countCerts(bundle []byte) int {
+	var n int
+	for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+		n++
+	}
+
+	return n
+}
@@ -0,0 +1,66 @@
+package tlscert
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// 客户端证书代表的节点角色。
+const (
+	RoleBroker = "broker"
+	RoleAgent  = "agent"
+)
+
+// IdentityScheme 身份 URI 的 scheme。
+const IdentityScheme = "aegis"
+
+// Identity 客户端证书代表的节点身份，以 URI SAN 的形式写入证书：aegis://broker/<id>。
+type Identity struct {
+	Role string // 节点角色，见 RoleBroker 和 RoleAgent
+	ID   string // 节点 ID
+}
+
+// URI 身份的 URI 形式，签发证书时写入 x509.Certificate.URIs。
+func (i Identity) URI() *url.URL {
+	return &url.URL{Scheme: IdentityScheme, Host: i.Role, Path: "/" + i.ID}
+}
+
+func (i Identity) String() string {
+	return i.URI().String()
+}
+
+// ParseIdentity 从证书的 URI SAN 中解析节点身份，证书中有且只能有一个身份。
+func ParseIdentity(cert *x509.Certificate) (Identity, error) {
+	var ident Identity
+	for _, u := range cert.URIs {
+		if u.Scheme != IdentityScheme {
+			continue
+		}
+		if ident.Role != "" {
+			return Identity{}, errors.New("tlscert: multiple identities in certificate")
+		}
+
+		ident = Identity{Role: u.Host, ID: strings.TrimPrefix(u.Path, "/")}
+		if err := ident.validate(); err != nil {
+			return Identity{}, err
+		}
+	}
+	if ident.Role == "" {
+		return Identity{}, errors.New("tlscert: no identity in certificate")
+	}
+
+	return ident, nil
+}
+
+func (i Identity) validate() error {
+	switch {
+	case i.Role != RoleBroker && i.Role != RoleAgent:
+		return errors.New("tlscert: unknown identity role " + i.Role)
+	case i.ID == "" || strings.Contains(i.ID, "/"):
+		return errors.New("tlscert: invalid identity id " + i.ID)
+	}
+
+	return nil
+}
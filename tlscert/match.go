@@ -32,6 +32,7 @@ type Matcher interface {
 	Reset()
 }
 
+// NewMatch 返回的 Matcher 同时实现了 Refresher，可以通过类型断言自动刷新证书池。
 func NewMatch(load LoadFunc, log *slog.Logger) Matcher {
 	return &certificateMatcher{
 		load: load,
@@ -119,12 +120,10 @@ func (m *certificateMatcher) slowLoadPool(parent context.Context) *certificatePo
 		return pool
 	}
 
-	pool := &certificatePool{certs: make(map[string][]*tls.Certificate, 16)}
-
 	ctx, cancel := context.WithTimeout(parent, time.Minute)
 	defer cancel()
 
-	pairs, err := m.load(ctx)
+	pool, err := m.loadPool(ctx)
 	if err != nil {
 		pool.err = err
 		// 可能是网络波动导致的超时问题，不放入结果缓存。
@@ -134,13 +133,42 @@ func (m *certificateMatcher) slowLoadPool(parent context.Context) *certificatePo
 
 		return pool
 	}
+	m.pool.Store(pool)
+
+	return pool
+}
+
+// Reload 重新加载证书池，加载成功后原子替换，加载失败时保留旧的证书池并返回错误。
+func (m *certificateMatcher) Reload(parent context.Context) error {
+	ctx, cancel := context.WithTimeout(parent, time.Minute)
+	defer cancel()
+
+	pool, err := m.loadPool(ctx)
+	if err != nil {
+		m.log.Warn("刷新证书池出错，继续使用旧的证书池", "error", err)
+		return err
+	}
+
+	m.mutex.Lock()
+	m.pool.Store(pool)
+	m.mutex.Unlock()
+	m.log.Info("刷新证书池完成", "certificates", pool.size)
+
+	return nil
+}
 
+// loadPool 加载证书并构建证书池，出错时返回的证书池为空。
+func (m *certificateMatcher) loadPool(ctx context.Context) (*certificatePool, error) {
+	pool := &certificatePool{certs: make(map[string][]*tls.Certificate, 16)}
+	pairs, err := m.load(ctx)
+	if err != nil {
+		return pool, err
+	}
 	for _, kp := range pairs {
 		pool.put(kp)
 	}
-	m.pool.Store(pool)
 
-	return pool
+	return pool, nil
 }
 
 func (m *certificateMatcher) selfSignature() (*tls.Certificate, error) {
@@ -199,6 +227,7 @@ func (m *certificateMatcher) selfSignature() (*tls.Certificate, error) {
 
 type certificatePool struct {
 	err   error
+	size  int // 证书数量
 	certs map[string][]*tls.Certificate
 }
 
@@ -234,6 +263,7 @@ func (cm *certificatePool) Match(sni string) (*tls.Certificate, error) {
 }
 
 func (cm *certificatePool) put(crt *tls.Certificate) {
+	cm.size++
 	leaf := crt.Leaf
 	for _, name := range leaf.DNSNames {
 		cm.certs[name] = append(cm.certs[name], crt)
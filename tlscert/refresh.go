@@ -0,0 +1,113 @@
+package tlscert
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// WatchFunc 监听证书数据的变更，每次变更后调用 changed，直到 ctx 取消或者出错才返回。
+type WatchFunc func(ctx context.Context, changed func()) error
+
+// RefreshConfig 证书池和内部 CA 的自动刷新配置。
+type RefreshConfig struct {
+	// Interval 周期性刷新的间隔，小于等于 0 时默认为 10min。
+	// 设置了 Watch 时仍然周期性刷新，作为变更通知丢失时的兜底。
+	Interval time.Duration
+
+	// Watch 证书数据的变更通知，为 nil 时只周期性刷新。出错后会在 RetryDelay 后重新监听，
+	// 重新监听时会刷新一次证书池，以免遗漏中断期间的变更。
+	Watch WatchFunc
+
+	// Debounce 收到变更通知后等待多久再刷新，用于合并短时间内的多次变更，小于等于 0 时默认为 1s。
+	Debounce time.Duration
+
+	// RetryDelay Watch 出错后的重试间隔，小于等于 0 时默认为 10s。
+	RetryDelay time.Duration
+}
+
+// Refresher 可以重新加载证书数据，NewMatch 返回的 Matcher 和 CA 都实现了该接口。
+type Refresher interface {
+	// Reload 立即重新加载一次，失败时继续使用旧的数据并返回错误。
+	Reload(ctx context.Context) error
+
+	// Refresh 按照配置自动刷新，直到 ctx 取消。
+	Refresh(ctx context.Context, cfg RefreshConfig) error
+}
+
+// Refresh 按照配置自动刷新证书池，直到 ctx 取消。
+func (m *certificateMatcher) Refresh(ctx context.Context, cfg RefreshConfig) error {
+	return refresh(ctx, cfg, m.log, m.Reload)
+}
+
+// refresh 周期性或者收到变更通知后调用 reload，直到 ctx 取消。
+func refresh(ctx context.Context, cfg RefreshConfig, log *slog.Logger, reload func(context.Context) error) error {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Minute
+	}
+	if cfg.Debounce <= 0 {
+		cfg.Debounce = time.Second
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = 10 * time.Second
+	}
+
+	changes := make(chan struct{}, 1)
+	changed := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	if cfg.Watch != nil {
+		go watch(ctx, cfg, log, changed)
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-changes:
+			log.Debug("证书数据发生变更，准备刷新")
+			timer := time.NewTimer(cfg.Debounce)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			// 等待期间的变更一并处理。
+			select {
+			case <-changes:
+			default:
+			}
+			ticker.Reset(cfg.Interval)
+		}
+		_ = reload(ctx)
+	}
+}
+
+func watch(ctx context.Context, cfg RefreshConfig, log *slog.Logger, changed func()) {
+	for retry := false; ; retry = true {
+		if retry {
+			changed()
+		}
+		err := cfg.Watch(ctx, changed)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warn("监听证书变更出错，稍后重试", "error", err, "retry_delay", cfg.RetryDelay)
+
+		timer := time.NewTimer(cfg.RetryDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 证书池和内部 CA 都可以自动刷新。
+var (
+	_ Refresher = (*certificateMatcher)(nil)
+	_ Refresher = (*certificateAuthority)(nil)
+)
+
+// TestMatcherReloadFailure 加载失败时继续使用旧的证书池。
+func TestMatcherReloadFailure(t *testing.T) {
+	crt := validCertificate(t, "a.example.com")
+	var fail atomic.Bool
+	load := func(context.Context) ([]*tls.Certificate, error) {
+		if fail.Load() {
+			return nil, errors.New("database unavailable")
+		}
+		return []*tls.Certificate{crt}, nil
+	}
+	m := NewMatch(load, slog.New(slog.DiscardHandler))
+	m.SetSelfSigned(false)
+	rf, ok := m.(Refresher)
+	if !ok {
+		t.Fatal("matcher does not implement Refresher")
+	}
+	ctx := context.Background()
+
+	if err := rf.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	hello := &tls.ClientHelloInfo{ServerName: "a.example.com"}
+	if got, _ := m.GetCertificate(hello); got != crt {
+		t.Fatal("certificate not loaded")
+	}
+
+	fail.Store(true)
+	if err := rf.Reload(ctx); err == nil {
+		t.Fatal("Reload() returned nil for a failed load")
+	}
+	if got, err := m.GetCertificate(hello); got != crt || err != nil {
+		t.Fatalf("GetCertificate() = %v, %v after failed reload, want the old certificate", got, err)
+	}
+}
+
+// TestRefreshWatch 收到变更通知后刷新，短时间内的多次通知合并为一次，Watch 出错后重新监听并刷新。
+func TestRefreshWatch(t *testing.T) {
+	reloads := make(chan struct{}, 16)
+	reload := func(context.Context) error {
+		reloads <- struct{}{}
+		return nil
+	}
+
+	var mutex sync.Mutex
+	var notify func()
+	watching := make(chan struct{}, 4)
+	fail := make(chan struct{}, 1)
+	watchFn := func(ctx context.Context, changed func()) error {
+		mutex.Lock()
+		notify = changed
+		mutex.Unlock()
+		watching <- struct{}{}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-fail:
+			return errors.New("change stream closed")
+		}
+	}
+	cfg := RefreshConfig{
+		Interval:   time.Hour,
+		Watch:      watchFn,
+		Debounce:   20 * time.Millisecond,
+		RetryDelay: time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- refresh(ctx, cfg, slog.New(slog.DiscardHandler), reload) }()
+
+	wait := func(ch <-chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %s", what)
+		}
+	}
+	wait(watching, "watch")
+
+	mutex.Lock()
+	changed := notify
+	mutex.Unlock()
+	for range 5 {
+		changed()
+	}
+	wait(reloads, "reload after change")
+	select {
+	case <-reloads:
+		t.Fatal("burst of changes triggered more than one reload")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Watch 出错，重新监听时刷新一次，避免遗漏中断期间的变更。
+	fail <- struct{}{}
+	wait(watching, "rewatch")
+	wait(reloads, "reload after rewatch")
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("refresh() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh did not return after cancel")
+	}
+}